@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"github.com/fedosgad/mirror_proxy/cert_generator"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	caCertFileName = "ca-cert.pem"
+	caKeyFileName  = "ca-key.pem"
+)
+
+// generateCA implements "ca" command: creates new root CA and writes it
+// in formats suitable for desktop and mobile trust stores.
+func generateCA(opts *CAOptions) error {
+	spec, err := cert_generator.ParseKeySpec(opts.KeyType, opts.KeySize, opts.KeyCurve)
+	if err != nil {
+		return err
+	}
+	if opts.Days <= 0 {
+		return fmt.Errorf("CA lifetime must be positive")
+	}
+	subject := pkix.Name{CommonName: opts.CommonName}
+	if opts.Organization != "" {
+		subject.Organization = []string{opts.Organization}
+	}
+
+	keyFile := filepath.Join(opts.OutDir, caKeyFileName)
+	if _, err := os.Stat(keyFile); err == nil {
+		return fmt.Errorf("%s already exists, refusing to overwrite", keyFile)
+	}
+
+	ca, err := cert_generator.GenerateCA(subject, time.Duration(opts.Days)*24*time.Hour, spec)
+	if err != nil {
+		return err
+	}
+	if err := writeCAFiles(ca, opts.OutDir, opts.Password); err != nil {
+		return err
+	}
+	log.Printf("CA fingerprint (SHA-256): %s", cert_generator.Fingerprint(ca.Leaf))
+	return nil
+}
+
+// getCA loads root CA from files given in options. If none are given, CA is loaded
+// from CA directory or, if it is empty too, generated (and saved to CA directory if it is set).
+func getCA(opts *Options) (tls.Certificate, error) {
+	certFile, keyFile := opts.CertFile, opts.KeyFile
+	if certFile == "" && opts.CADir != "" {
+		certFile = filepath.Join(opts.CADir, caCertFileName)
+		keyFile = filepath.Join(opts.CADir, caKeyFileName)
+		if _, err := os.Stat(keyFile); err != nil {
+			certFile, keyFile = "", ""
+		}
+	}
+
+	var ca tls.Certificate
+	var err error
+	if certFile != "" {
+		ca, err = tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		ca.Leaf, err = x509.ParseCertificate(ca.Certificate[0])
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		log.Printf("Using CA from %s", certFile)
+	} else {
+		ca, err = cert_generator.GenerateCA(
+			pkix.Name{CommonName: "mirror_proxy CA", Organization: []string{"mirror_proxy"}},
+			10*365*24*time.Hour,
+			cert_generator.KeySpec{Algorithm: cert_generator.KeyRSA, RSABits: 2048},
+		)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+		if opts.CADir != "" {
+			if err := writeCAFiles(ca, opts.CADir, ""); err != nil {
+				return tls.Certificate{}, err
+			}
+		} else {
+			log.Printf("Using ephemeral CA, it will be lost on exit")
+		}
+	}
+	log.Printf("CA fingerprint (SHA-256): %s", cert_generator.Fingerprint(ca.Leaf))
+	return ca, nil
+}
+
+// setIntermediate loads intermediate CA from files given in options or generates it if requested.
+func setIntermediate(opts *Options, cg *cert_generator.CertificateGenerator) error {
+	var intermediate tls.Certificate
+	var err error
+	switch {
+	case opts.IntermediateCert != "":
+		intermediate, err = tls.LoadX509KeyPair(opts.IntermediateCert, opts.IntermediateKey)
+	case opts.GenIntermediate:
+		intermediate, err = cg.GenerateIntermediate(
+			pkix.Name{CommonName: "mirror_proxy Intermediate CA", Organization: []string{"mirror_proxy"}},
+			365*24*time.Hour,
+			cert_generator.KeySpec{Algorithm: cert_generator.KeyRSA, RSABits: 2048},
+		)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return cg.SetIntermediate(intermediate)
+}
+
+// writeCAFiles writes CA certificate and key into dir in all supported formats
+func writeCAFiles(ca tls.Certificate, dir, password string) error {
+	keyPEM, err := cert_generator.KeyPEM(ca)
+	if err != nil {
+		return err
+	}
+	p12, err := cert_generator.PKCS12(ca, password)
+	if err != nil {
+		return err
+	}
+	mobileConfig, err := cert_generator.MobileConfig(ca.Leaf)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	files := []struct {
+		name string
+		data []byte
+		perm os.FileMode
+	}{
+		{caKeyFileName, keyPEM, 0600},
+		{caCertFileName, cert_generator.CertPEM(ca.Leaf), 0644},
+		{"ca-cert.der", ca.Leaf.Raw, 0644},
+		{"ca.p12", p12, 0600},
+		{"ca.mobileconfig", mobileConfig, 0644},
+		{cert_generator.AndroidFileName(ca.Leaf), cert_generator.CertPEM(ca.Leaf), 0644},
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.name)
+		if err := os.WriteFile(path, f.data, f.perm); err != nil {
+			return err
+		}
+		log.Printf("Written %s", path)
+	}
+	return nil
+}
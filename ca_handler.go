@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/x509"
+	"github.com/elazarl/goproxy"
+	"github.com/fedosgad/mirror_proxy/cert_generator"
+	"html/template"
+	"log"
+	"net/http"
+	"net/http/httptest"
+)
+
+// caHost is a magic hostname answered by proxy itself (for devices configured to use it)
+const caHost = "mirror.proxy"
+
+var caPageTemplate = template.Must(template.New("ca").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>mirror_proxy CA</title>
+</head>
+<body>
+<h1>mirror_proxy CA certificate</h1>
+<p>SHA-256 fingerprint: <code>{{.Fingerprint}}</code></p>
+<ul>
+<li><a href="/ca.pem">PEM</a> (Linux, Firefox, most tools)</li>
+<li><a href="/ca.der">DER</a> (Windows)</li>
+<li><a href="/ca.mobileconfig">Configuration profile</a> (iOS, macOS)</li>
+<li><a href="/ca.crt">CRT</a> (Android user store)</li>
+<li><a href="/{{.AndroidFileName}}">{{.AndroidFileName}}</a> (Android system store, <code>/system/etc/security/cacerts/</code>)</li>
+</ul>
+</body>
+</html>
+`))
+
+// newCAHandler serves root CA certificate so that devices can download it from proxy.
+func newCAHandler(ca *x509.Certificate) *http.ServeMux {
+	certPEM := cert_generator.CertPEM(ca)
+	androidFileName := cert_generator.AndroidFileName(ca)
+	mobileConfig, err := cert_generator.MobileConfig(ca)
+	if err != nil {
+		log.Printf("Error building configuration profile: %v", err)
+	}
+
+	serve := func(contentType string, data []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			_, _ = w.Write(data)
+		}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_ = caPageTemplate.Execute(w, struct {
+			Fingerprint     string
+			AndroidFileName string
+		}{
+			Fingerprint:     cert_generator.Fingerprint(ca),
+			AndroidFileName: androidFileName,
+		})
+	})
+	mux.Handle("/ca.pem", serve("application/x-pem-file", certPEM))
+	mux.Handle("/ca.der", serve("application/x-x509-ca-cert", ca.Raw))
+	mux.Handle("/ca.crt", serve("application/x-x509-ca-cert", ca.Raw))
+	mux.Handle("/"+androidFileName, serve("application/octet-stream", certPEM))
+	if mobileConfig != nil {
+		mux.Handle("/ca.mobileconfig", serve("application/x-apple-aspen-config", mobileConfig))
+	}
+	return mux
+}
+
+// handleCAHost makes proxy answer requests to caHost with handler
+func handleCAHost(p *goproxy.ProxyHttpServer, handler http.Handler) {
+	p.OnRequest(goproxy.DstHostIs(caHost)).DoFunc(
+		func(req *http.Request, ctx *goproxy.ProxyCtx) (*http.Request, *http.Response) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			resp := rec.Result()
+			resp.Request = req
+			return req, resp
+		})
+}
@@ -0,0 +1,67 @@
+package cert_generator
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// GenerateCA creates self-signed root CA certificate with a new key of given spec.
+func GenerateCA(subject pkix.Name, lifetime time.Duration, spec KeySpec) (tls.Certificate, error) {
+	key, err := spec.generate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	_, sigAlg, err := signerFor(key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template, err := caTemplate(subject, lifetime, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.SignatureAlgorithm = sigAlg
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating CA certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+func caTemplate(subject pkix.Name, lifetime time.Duration, key crypto.Signer) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		return nil, err
+	}
+	ski := sha1.Sum(pubDER)
+
+	return &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().AddDate(0, 0, -7),
+		NotAfter:              time.Now().Add(lifetime),
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		SubjectKeyId:          ski[:],
+	}, nil
+}
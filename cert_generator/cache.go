@@ -0,0 +1,157 @@
+package cert_generator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CertCache is a concurrency-safe LRU cache of forged certificates keyed by SAN set.
+// Concurrent misses for the same key result in a single generation.
+type CertCache struct {
+	cg   *CertificateGenerator
+	size int
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	lru      *list.List // front is the most recently used
+	items    map[string]*list.Element
+	inflight map[string]*certCall
+
+	hits   atomic.Uint64
+	misses atomic.Uint64
+}
+
+type cacheEntry struct {
+	key     string
+	cert    *tls.Certificate
+	expires time.Time
+}
+
+// certCall is an in-progress certificate generation
+type certCall struct {
+	done chan struct{}
+	cert *tls.Certificate
+	err  error
+}
+
+// NewCertCache wraps generator with cache holding up to size certificates for ttl each.
+func NewCertCache(cg *CertificateGenerator, size int, ttl time.Duration) *CertCache {
+	return &CertCache{
+		cg:       cg,
+		size:     size,
+		ttl:      ttl,
+		lru:      list.New(),
+		items:    make(map[string]*list.Element),
+		inflight: make(map[string]*certCall),
+	}
+}
+
+// GenChildCert returns cached certificate for given SANs or generates a new one.
+func (c *CertCache) GenChildCert(ips, names []string) (*tls.Certificate, error) {
+	return c.getOrGenerate(cacheKey(ips, names), func() (*tls.Certificate, error) {
+		return c.cg.GenChildCert(ips, names)
+	})
+}
+
+// GenMirroredCert returns cached certificate mirroring upstream one or generates a new one.
+func (c *CertCache) GenMirroredCert(upstream *x509.Certificate) (*tls.Certificate, error) {
+	sum := sha256.Sum256(upstream.Raw)
+	return c.getOrGenerate("mirror:"+hex.EncodeToString(sum[:]), func() (*tls.Certificate, error) {
+		return c.cg.GenMirroredCert(upstream)
+	})
+}
+
+func (c *CertCache) getOrGenerate(key string, generate func() (*tls.Certificate, error)) (*tls.Certificate, error) {
+	c.mu.Lock()
+	if cert, ok := c.get(key); ok {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		return cert, nil
+	}
+	c.misses.Add(1)
+	if call, ok := c.inflight[key]; ok {
+		c.mu.Unlock()
+		<-call.done
+		return call.cert, call.err
+	}
+	call := &certCall{done: make(chan struct{})}
+	c.inflight[key] = call
+	c.mu.Unlock()
+
+	call.cert, call.err = generate()
+
+	c.mu.Lock()
+	delete(c.inflight, key)
+	if call.err == nil {
+		c.put(key, call.cert)
+	}
+	c.mu.Unlock()
+	close(call.done)
+
+	return call.cert, call.err
+}
+
+// Stats returns number of cache hits and misses since creation.
+func (c *CertCache) Stats() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// get MUST be called with c.mu held
+func (c *CertCache) get(key string) (*tls.Certificate, bool) {
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*cacheEntry)
+	if time.Now().After(entry.expires) {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return entry.cert, true
+}
+
+// put MUST be called with c.mu held
+func (c *CertCache) put(key string, cert *tls.Certificate) {
+	if c.size <= 0 {
+		return
+	}
+	entry := &cacheEntry{
+		key:     key,
+		cert:    cert,
+		expires: time.Now().Add(c.ttl),
+	}
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// cacheKey builds order-independent key from SAN set
+func cacheKey(ips, names []string) string {
+	sans := make([]string, 0, len(ips)+len(names))
+	for _, ip := range ips {
+		sans = append(sans, "ip:"+ip)
+	}
+	for _, name := range names {
+		sans = append(sans, "dns:"+strings.ToLower(name))
+	}
+	sort.Strings(sans)
+	return strings.Join(sans, ",")
+}
@@ -0,0 +1,136 @@
+package cert_generator
+
+import (
+	"crypto/elliptic"
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, size int, ttl time.Duration) *CertCache {
+	t.Helper()
+	spec := KeySpec{Algorithm: KeyECDSA, Curve: elliptic.P256()}
+	ca, err := GenerateCA(pkix.Name{CommonName: "test CA"}, time.Hour, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kp := NewKeyPool(0, spec)
+	cg, err := NewCertGenerator(ca, kp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewCertCache(cg, size, ttl)
+}
+
+func TestCertCacheKey(t *testing.T) {
+	c := newTestCache(t, 8, time.Hour)
+	cert, err := c.GenChildCert([]string{"192.0.2.1"}, []string{"Example.com", "www.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf := cert.Leaf; leaf != nil && (len(leaf.DNSNames) != 2 || len(leaf.IPAddresses) != 1) {
+		t.Errorf("SANs: %v %v", leaf.DNSNames, leaf.IPAddresses)
+	}
+	// SAN order and name case do not matter
+	again, err := c.GenChildCert([]string{"192.0.2.1"}, []string{"www.example.com", "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != cert {
+		t.Error("same SAN set is not cached")
+	}
+	other, _ := c.GenChildCert(nil, []string{"example.com"})
+	if other == cert {
+		t.Error("different SAN set hits cache")
+	}
+	if hits, misses := c.Stats(); hits != 1 || misses != 2 {
+		t.Errorf("stats = %d hits, %d misses", hits, misses)
+	}
+}
+
+func TestCertCacheTTLAndSize(t *testing.T) {
+	var calls atomic.Int32
+	gen := func() (*tls.Certificate, error) {
+		calls.Add(1)
+		return &tls.Certificate{}, nil
+	}
+	c := newTestCache(t, 2, 50*time.Millisecond)
+
+	first, _ := c.getOrGenerate("a", gen)
+	if cert, _ := c.getOrGenerate("a", gen); cert != first || calls.Load() != 1 {
+		t.Fatal("certificate is not cached")
+	}
+	time.Sleep(60 * time.Millisecond)
+	if cert, _ := c.getOrGenerate("a", gen); cert == first || calls.Load() != 2 {
+		t.Error("expired certificate is returned")
+	}
+
+	// "a" is the least recently used one and is evicted
+	_, _ = c.getOrGenerate("b", gen)
+	_, _ = c.getOrGenerate("c", gen)
+	calls.Store(0)
+	_, _ = c.getOrGenerate("c", gen)
+	_, _ = c.getOrGenerate("b", gen)
+	_, _ = c.getOrGenerate("a", gen)
+	if calls.Load() != 1 {
+		t.Errorf("%d certificates generated, want 1", calls.Load())
+	}
+
+	// Errors are not cached
+	errGen := errors.New("generation failed")
+	for i := 0; i < 2; i++ {
+		if _, err := c.getOrGenerate("d", func() (*tls.Certificate, error) { return nil, errGen }); err != errGen {
+			t.Errorf("error = %v", err)
+		}
+	}
+	if hits, _ := c.Stats(); hits != 3 {
+		t.Errorf("%d hits, want 3", hits)
+	}
+}
+
+func TestCertCacheSingleFlight(t *testing.T) {
+	c := newTestCache(t, 8, time.Hour)
+	const callers = 10
+	var calls atomic.Int32
+	release := make(chan struct{})
+	gen := func() (*tls.Certificate, error) {
+		calls.Add(1)
+		<-release
+		return &tls.Certificate{}, nil
+	}
+
+	var wg sync.WaitGroup
+	certs := make([]*tls.Certificate, callers)
+	for i := range certs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			certs[i], _ = c.getOrGenerate("key", gen)
+		}(i)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, misses := c.Stats(); misses == callers {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("callers did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	if calls.Load() != 1 {
+		t.Errorf("certificate generated %d times", calls.Load())
+	}
+	for _, cert := range certs {
+		if cert != certs[0] {
+			t.Fatal("callers got different certificates")
+		}
+	}
+}
@@ -1,6 +1,10 @@
 package cert_generator
 
 import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
@@ -12,19 +16,119 @@ import (
 )
 
 type CertificateGenerator struct {
-	ca     tls.Certificate
-	caX509 *x509.Certificate
+	ca       tls.Certificate
+	caX509   *x509.Certificate
+	leafKeys *KeyPool
+
+	// Leaves are signed by issuer: either CA itself or intermediate CA
+	issuer    *x509.Certificate
+	issuerKey crypto.Signer
+	sigAlg    x509.SignatureAlgorithm
+	// chain is appended to every leaf certificate
+	chain [][]byte
 }
 
-func NewCertGenerator(ca tls.Certificate) (*CertificateGenerator, error) {
+// NewCertGenerator creates generator which signs leaf certificates with ca.
+// RSA, ECDSA and Ed25519 CA keys are supported.
+func NewCertGenerator(ca tls.Certificate, leafKeys *KeyPool) (*CertificateGenerator, error) {
 	caX509, err := x509.ParseCertificate(ca.Certificate[0])
 	if err != nil {
 		return nil, err
 	}
-	return &CertificateGenerator{ca: ca, caX509: caX509}, nil
+	caKey, sigAlg, err := signerFor(ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateGenerator{
+		ca:        ca,
+		caX509:    caX509,
+		leafKeys:  leafKeys,
+		issuer:    caX509,
+		issuerKey: caKey,
+		sigAlg:    sigAlg,
+	}, nil
 }
 
-func NewCertGeneratorFromFiles(certFile, keyFile string) (*CertificateGenerator, error) {
+// SetIntermediate makes generator sign leaves with intermediate CA (which MUST be issued by root CA).
+// Intermediate certificate(s) are served along with every leaf.
+func (cg *CertificateGenerator) SetIntermediate(intermediate tls.Certificate) error {
+	intermediateX509, err := x509.ParseCertificate(intermediate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	if err := intermediateX509.CheckSignatureFrom(cg.caX509); err != nil {
+		return fmt.Errorf("intermediate is not issued by CA: %v", err)
+	}
+	key, sigAlg, err := signerFor(intermediate.PrivateKey)
+	if err != nil {
+		return err
+	}
+	cg.issuer = intermediateX509
+	cg.issuerKey = key
+	cg.sigAlg = sigAlg
+	cg.chain = intermediate.Certificate
+	return nil
+}
+
+// GenerateIntermediate creates intermediate CA issued by root CA. Its lifetime is limited by root's one.
+func (cg *CertificateGenerator) GenerateIntermediate(subject pkix.Name, lifetime time.Duration, spec KeySpec) (tls.Certificate, error) {
+	key, err := spec.generate()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template, err := caTemplate(subject, lifetime, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if template.NotAfter.After(cg.caX509.NotAfter) {
+		template.NotAfter = cg.caX509.NotAfter
+	}
+	template.MaxPathLenZero = true
+	caKey, sigAlg, err := signerFor(cg.ca.PrivateKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template.SignatureAlgorithm = sigAlg
+
+	der, err := x509.CreateCertificate(rand.Reader, template, cg.caX509, key.Public(), caKey)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("creating intermediate certificate: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// signerFor checks CA key type and picks signature algorithm matching it.
+func signerFor(key crypto.PrivateKey) (crypto.Signer, x509.SignatureAlgorithm, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return k, x509.SHA256WithRSA, nil
+	case *ecdsa.PrivateKey:
+		switch k.Curve {
+		case elliptic.P256():
+			return k, x509.ECDSAWithSHA256, nil
+		case elliptic.P384():
+			return k, x509.ECDSAWithSHA384, nil
+		case elliptic.P521():
+			return k, x509.ECDSAWithSHA512, nil
+		default:
+			return nil, x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported CA key curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		return k, x509.PureEd25519, nil
+	default:
+		return nil, x509.UnknownSignatureAlgorithm, fmt.Errorf("unsupported CA key type %T", key)
+	}
+}
+
+func NewCertGeneratorFromFiles(certFile, keyFile string, leafKeys *KeyPool) (*CertificateGenerator, error) {
 	var certs []tls.Certificate
 	if certFile == "" || keyFile == "" {
 		return nil, fmt.Errorf("certificate and key files required")
@@ -34,29 +138,66 @@ func NewCertGeneratorFromFiles(certFile, keyFile string) (*CertificateGenerator,
 		return nil, err
 	}
 	certs = []tls.Certificate{cert}
-	return NewCertGenerator(certs[0])
+	return NewCertGenerator(certs[0], leafKeys)
 }
 
 func (cg *CertificateGenerator) GenChildCert(ips, names []string) (*tls.Certificate, error) {
-	private, cab, err := cg.genCertBytes(ips, names)
+	template := cg.newTemplate()
+	if ips != nil {
+		is := make([]net.IP, 0)
+		for _, i := range ips {
+			is = append(is, net.ParseIP(i))
+		}
+		template.IPAddresses = is
+	}
+	if names != nil {
+		template.DNSNames = names
+	}
+
+	// Every leaf gets its own key pair, CA key never leaves the generator
+	leafKey, err := cg.leafKeys.Get()
 	if err != nil {
 		return nil, err
 	}
+	return cg.signLeaf(template, leafKey)
+}
 
-	return &tls.Certificate{
-		Certificate: [][]byte{cab},
-		PrivateKey:  private,
-	}, nil
+// GenMirroredCert forges certificate resembling upstream one: subject, SANs, validity period,
+// extended key usages and key type/size are copied.
+func (cg *CertificateGenerator) GenMirroredCert(upstream *x509.Certificate) (*tls.Certificate, error) {
+	template := cg.newTemplate()
+	template.RawSubject = upstream.RawSubject
+	template.DNSNames = upstream.DNSNames
+	template.IPAddresses = upstream.IPAddresses
+	template.URIs = upstream.URIs
+	template.EmailAddresses = upstream.EmailAddresses
+	template.NotBefore = upstream.NotBefore
+	template.NotAfter = upstream.NotAfter
+	template.ExtKeyUsage = upstream.ExtKeyUsage
+	template.UnknownExtKeyUsage = upstream.UnknownExtKeyUsage
+
+	var leafKey crypto.Signer
+	var err error
+	spec, ok := keySpecOf(upstream.PublicKey)
+	if ok {
+		leafKey, err = cg.leafKeys.GetSpec(spec)
+	} else {
+		leafKey, err = cg.leafKeys.Get()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return cg.signLeaf(template, leafKey)
 }
 
-func (cg *CertificateGenerator) genCertBytes(ips []string, names []string) (*rsa.PrivateKey, []byte, error) {
+func (cg *CertificateGenerator) newTemplate() *x509.Certificate {
 	s, _ := rand.Prime(rand.Reader, 128)
 
 	// Certificate validity period should be less than 13 month.
 	// See https://stackoverflow.com/a/65239775
 	// Thanks to Johnny Bravo for the tip!
 
-	template := &x509.Certificate{
+	return &x509.Certificate{
 		SerialNumber:          s,
 		Subject:               pkix.Name{Organization: []string{"mitmproxy"}},
 		Issuer:                pkix.Name{Organization: []string{"mitmproxy"}},
@@ -66,26 +207,22 @@ func (cg *CertificateGenerator) genCertBytes(ips []string, names []string) (*rsa
 		IsCA:                  false,
 		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		SignatureAlgorithm:    cg.sigAlg,
 	}
-	if ips != nil {
-		is := make([]net.IP, 0)
-		for _, i := range ips {
-			is = append(is, net.ParseIP(i))
-		}
-		template.IPAddresses = is
-	}
-	if names != nil {
-		template.DNSNames = names
-	}
-
-	private := cg.ca.PrivateKey.(*rsa.PrivateKey)
+}
 
-	certP, _ := x509.ParseCertificate(cg.ca.Certificate[0])
-	public := certP.PublicKey.(*rsa.PublicKey)
+func (cg *CertificateGenerator) signLeaf(template *x509.Certificate, leafKey crypto.Signer) (*tls.Certificate, error) {
+	if _, ok := leafKey.(*rsa.PrivateKey); !ok {
+		// KeyEncipherment is only meaningful for RSA keys
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+	}
 
-	cab, err := x509.CreateCertificate(rand.Reader, template, cg.caX509, public, private)
+	cab, err := x509.CreateCertificate(rand.Reader, template, cg.issuer, leafKey.Public(), cg.issuerKey)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
-	return private, cab, nil
+	return &tls.Certificate{
+		Certificate: append([][]byte{cab}, cg.chain...),
+		PrivateKey:  leafKey,
+	}, nil
 }
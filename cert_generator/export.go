@@ -0,0 +1,143 @@
+package cert_generator
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"encoding/xml"
+	"fmt"
+	"software.sslmate.com/src/go-pkcs12"
+	"strings"
+	"text/template"
+)
+
+// CertPEM encodes certificate in PEM format.
+func CertPEM(cert *x509.Certificate) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+}
+
+// KeyPEM encodes private key in PKCS#8 PEM format.
+func KeyPEM(cert tls.Certificate) ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// PKCS12 bundles certificate and its key into password-protected PKCS#12 archive.
+// Legacy encryption is used, because that is what most mobile OSes are able to import.
+func PKCS12(cert tls.Certificate, password string) ([]byte, error) {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return pkcs12.LegacyDES.Encode(cert.PrivateKey, leaf, nil, password)
+}
+
+// AndroidFileName returns name under which certificate should be placed
+// into Android system store (/system/etc/security/cacerts/<subject_hash_old>.0).
+func AndroidFileName(cert *x509.Certificate) string {
+	sum := md5.Sum(cert.RawSubject)
+	return fmt.Sprintf("%08x.0", binary.LittleEndian.Uint32(sum[:4]))
+}
+
+// Fingerprint returns SHA-256 fingerprint of certificate in colon-separated hex.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+var mobileConfigTemplate = template.Must(template.New("mobileconfig").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>PayloadContent</key>
+	<array>
+		<dict>
+			<key>PayloadCertificateFileName</key>
+			<string>{{.Name}}.cer</string>
+			<key>PayloadContent</key>
+			<data>{{.Data}}</data>
+			<key>PayloadDescription</key>
+			<string>Adds a CA root certificate</string>
+			<key>PayloadDisplayName</key>
+			<string>{{.Name}}</string>
+			<key>PayloadIdentifier</key>
+			<string>com.apple.security.root.{{.CertUUID}}</string>
+			<key>PayloadType</key>
+			<string>com.apple.security.root</string>
+			<key>PayloadUUID</key>
+			<string>{{.CertUUID}}</string>
+			<key>PayloadVersion</key>
+			<integer>1</integer>
+		</dict>
+	</array>
+	<key>PayloadDisplayName</key>
+	<string>{{.Name}}</string>
+	<key>PayloadIdentifier</key>
+	<string>mirror_proxy.{{.ProfileUUID}}</string>
+	<key>PayloadRemovalDisallowed</key>
+	<false/>
+	<key>PayloadType</key>
+	<string>Configuration</string>
+	<key>PayloadUUID</key>
+	<string>{{.ProfileUUID}}</string>
+	<key>PayloadVersion</key>
+	<integer>1</integer>
+</dict>
+</plist>
+`))
+
+// MobileConfig builds iOS configuration profile which installs certificate as a trusted root.
+func MobileConfig(cert *x509.Certificate) ([]byte, error) {
+	certUUID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	profileUUID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = "mirror_proxy CA"
+	}
+	var escapedName bytes.Buffer
+	_ = xml.EscapeText(&escapedName, []byte(name))
+
+	var buf bytes.Buffer
+	err = mobileConfigTemplate.Execute(&buf, struct {
+		Name        string
+		Data        string
+		CertUUID    string
+		ProfileUUID string
+	}{
+		Name:        escapedName.String(),
+		Data:        base64.StdEncoding.EncodeToString(cert.Raw),
+		CertUUID:    certUUID,
+		ProfileUUID: profileUUID,
+	})
+	return buf.Bytes(), err
+}
+
+// newUUID generates random (version 4) UUID
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16]), nil
+}
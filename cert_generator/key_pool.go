@@ -0,0 +1,181 @@
+package cert_generator
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Leaf key algorithms
+const (
+	KeyRSA   = "rsa"
+	KeyECDSA = "ecdsa"
+)
+
+// KeySpec describes a leaf key pair: algorithm and its size (RSA) or curve (ECDSA).
+type KeySpec struct {
+	Algorithm string
+	RSABits   int
+	Curve     elliptic.Curve
+}
+
+// ParseKeySpec builds KeySpec from command line values. Curve is one of P256, P384, P521.
+func ParseKeySpec(algorithm string, rsaBits int, curve string) (KeySpec, error) {
+	switch strings.ToLower(algorithm) {
+	case KeyRSA:
+		if rsaBits < 1024 {
+			return KeySpec{}, fmt.Errorf("RSA key size %d is too small", rsaBits)
+		}
+		return KeySpec{Algorithm: KeyRSA, RSABits: rsaBits}, nil
+	case KeyECDSA:
+		c, err := parseCurve(curve)
+		if err != nil {
+			return KeySpec{}, err
+		}
+		return KeySpec{Algorithm: KeyECDSA, Curve: c}, nil
+	default:
+		return KeySpec{}, fmt.Errorf("unknown key algorithm %q", algorithm)
+	}
+}
+
+func parseCurve(name string) (elliptic.Curve, error) {
+	switch strings.ToUpper(strings.ReplaceAll(name, "-", "")) {
+	case "P256":
+		return elliptic.P256(), nil
+	case "P384":
+		return elliptic.P384(), nil
+	case "P521":
+		return elliptic.P521(), nil
+	default:
+		return nil, fmt.Errorf("unknown curve %q", name)
+	}
+}
+
+// keySpecOf returns KeySpec matching given public key, if its type is supported.
+func keySpecOf(pub crypto.PublicKey) (KeySpec, bool) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return KeySpec{Algorithm: KeyRSA, RSABits: k.N.BitLen()}, true
+	case *ecdsa.PublicKey:
+		if _, err := parseCurve(k.Curve.Params().Name); err != nil {
+			return KeySpec{}, false
+		}
+		return KeySpec{Algorithm: KeyECDSA, Curve: k.Curve}, true
+	default:
+		return KeySpec{}, false
+	}
+}
+
+func (s KeySpec) String() string {
+	if s.Algorithm == KeyECDSA {
+		return fmt.Sprintf("%s-%s", s.Algorithm, s.Curve.Params().Name)
+	}
+	return fmt.Sprintf("%s-%d", s.Algorithm, s.RSABits)
+}
+
+func (s KeySpec) generate() (crypto.Signer, error) {
+	switch s.Algorithm {
+	case KeyRSA:
+		return rsa.GenerateKey(rand.Reader, s.RSABits)
+	case KeyECDSA:
+		return ecdsa.GenerateKey(s.Curve, rand.Reader)
+	default:
+		return nil, fmt.Errorf("unknown key algorithm %q", s.Algorithm)
+	}
+}
+
+// KeyPool keeps pre-generated leaf key pairs so that certificate forging
+// does not have to wait for (slow) RSA key generation.
+// Each key is handed out only once.
+type KeyPool struct {
+	defaultSpec KeySpec
+	keys        chan crypto.Signer
+
+	stop      chan struct{}
+	done      chan struct{} // closed when filler exits
+	closeOnce sync.Once
+}
+
+// Delays between retries of failed key generation
+const (
+	minFillBackoff = time.Second
+	maxFillBackoff = time.Minute
+)
+
+// NewKeyPool creates pool which keeps up to size keys of defaultSpec. Filling starts immediately.
+// Keys of other specs (e.g. mirrored from upstream certificates) are generated on demand,
+// so that rarely used specs do not keep filler goroutines and keys around.
+// If size is 0, all keys are generated on demand.
+func NewKeyPool(size int, defaultSpec KeySpec) *KeyPool {
+	kp := &KeyPool{
+		defaultSpec: defaultSpec,
+		keys:        make(chan crypto.Signer, size),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if size > 0 {
+		go kp.fill()
+	} else {
+		close(kp.done)
+	}
+	return kp
+}
+
+// Get returns new key pair of default spec.
+func (kp *KeyPool) Get() (crypto.Signer, error) {
+	return kp.GetSpec(kp.defaultSpec)
+}
+
+// GetSpec returns new key pair of given spec. Pre-generated key is used if available.
+func (kp *KeyPool) GetSpec(spec KeySpec) (crypto.Signer, error) {
+	if spec.String() != kp.defaultSpec.String() {
+		return spec.generate()
+	}
+	select {
+	case key := <-kp.keys:
+		return key, nil
+	default:
+		return spec.generate()
+	}
+}
+
+// Close stops filling the pool and waits for filler to exit. Keys are still generated on demand after Close.
+func (kp *KeyPool) Close() {
+	kp.closeOnce.Do(func() {
+		close(kp.stop)
+	})
+	<-kp.done
+}
+
+// fill keeps pool full. It blocks on send when the pool is full.
+// Generation errors are logged and retried with growing delay.
+func (kp *KeyPool) fill() {
+	defer close(kp.done)
+	backoff := minFillBackoff
+	for {
+		key, err := kp.defaultSpec.generate()
+		if err != nil {
+			log.Printf("Error pre-generating %s leaf key (retrying in %v): %v", kp.defaultSpec, backoff, err)
+			select {
+			case <-time.After(backoff):
+			case <-kp.stop:
+				return
+			}
+			backoff = min(2*backoff, maxFillBackoff)
+			continue
+		}
+		backoff = minFillBackoff
+		select {
+		case kp.keys <- key:
+		case <-kp.stop:
+			return
+		}
+	}
+}
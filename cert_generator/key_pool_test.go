@@ -0,0 +1,79 @@
+package cert_generator
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"testing"
+	"time"
+)
+
+func TestKeyPool(t *testing.T) {
+	spec := KeySpec{Algorithm: KeyECDSA, Curve: elliptic.P256()}
+	kp := NewKeyPool(2, spec)
+	defer kp.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(kp.keys) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("pool is not filled")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	seen := make(map[*ecdsa.PrivateKey]bool)
+	for i := 0; i < 4; i++ { // more than pool size, the rest is generated on demand
+		key, err := kp.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok || k.Curve != elliptic.P256() {
+			t.Fatalf("got %T key", key)
+		}
+		if seen[k] {
+			t.Fatal("key handed out twice")
+		}
+		seen[k] = true
+	}
+
+	key, err := kp.GetSpec(KeySpec{Algorithm: KeyRSA, RSABits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := key.(*rsa.PrivateKey); !ok || k.N.BitLen() != 1024 {
+		t.Errorf("got %T key for other spec", key)
+	}
+}
+
+func TestKeyPoolClose(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		spec KeySpec
+	}{
+		{"full", 1, KeySpec{Algorithm: KeyECDSA, Curve: elliptic.P256()}},
+		{"failing", 1, KeySpec{Algorithm: "unknown"}}, // filler waits before retry
+		{"on demand", 0, KeySpec{Algorithm: KeyECDSA, Curve: elliptic.P256()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kp := NewKeyPool(tt.size, tt.spec)
+			time.Sleep(10 * time.Millisecond)
+			closed := make(chan struct{})
+			go func() {
+				kp.Close()
+				kp.Close()
+				close(closed)
+			}()
+			select {
+			case <-closed:
+			case <-time.After(minFillBackoff / 2):
+				t.Fatal("Close does not stop filler")
+			}
+			if _, err := kp.Get(); (err != nil) != (tt.spec.Algorithm == "unknown") {
+				t.Errorf("Get after Close: %v", err)
+			}
+		})
+	}
+}
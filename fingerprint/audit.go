@@ -0,0 +1,201 @@
+package fingerprint
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// greasePlaceholder replaces GREASE values before comparison, as they are random by design
+const greasePlaceholder = 0x0a0a
+
+// Extensions whose contents are expected to differ between ClientHellos of the same client
+var volatileExtensions = map[uint16]bool{
+	extServerName: true, // compared separately
+	extKeyShare:   true, // key material, groups are compared separately
+	21:            true, // padding, length depends on other fields
+	35:            true, // session_ticket
+	41:            true, // pre_shared_key
+	42:            true, // early_data
+	44:            true, // cookie
+	0xfe0d:        true, // encrypted_client_hello
+}
+
+// Extensions whose contents are compared as parsed fields, with GREASE values normalized
+var parsedExtensions = map[uint16]bool{
+	extSupportedGroups:     true,
+	extECPointFormats:      true,
+	extSignatureAlgorithms: true,
+	extALPN:                true,
+	extSupportedVersions:   true,
+	extPSKModes:            true,
+}
+
+// Audit is a result of comparison between ClientHello sent by client
+// and the one sent upstream.
+type Audit struct {
+	Match      bool       `json:"match"`
+	JA3Hash    string     `json:"ja3_hash"`
+	JA4        string     `json:"ja4"`
+	Mismatches []Mismatch `json:"mismatches,omitempty"`
+}
+
+// Mismatch describes single differing ClientHello field.
+type Mismatch struct {
+	Field    string `json:"field"`
+	Original string `json:"original"`
+	Emitted  string `json:"emitted"`
+	Details  string `json:"details,omitempty"`
+}
+
+func (m Mismatch) String() string {
+	if m.Details != "" {
+		return fmt.Sprintf("%s: %s (original %s, emitted %s)", m.Field, m.Details, m.Original, m.Emitted)
+	}
+	return fmt.Sprintf("%s: original %s, emitted %s", m.Field, m.Original, m.Emitted)
+}
+
+// Compare compares original ClientHello with emitted one field by field.
+// Random, session ID, key shares contents and GREASE values are ignored.
+func Compare(original, emitted *ClientHello) *Audit {
+	a := &Audit{}
+	a.JA3Hash = ja3Hash(emitted)
+	a.JA4 = JA4(emitted)
+
+	a.compareValue("record_version", original.RecordVersion, emitted.RecordVersion)
+	a.compareValue("version", original.Version, emitted.Version)
+	a.compareList("cipher_suites", original.CipherSuites, emitted.CipherSuites)
+	a.compareInts("compression_methods", original.CompressionMethods, emitted.CompressionMethods)
+	a.compareList("extensions", original.Extensions, emitted.Extensions)
+	a.compareValue("server_name", original.ServerName, emitted.ServerName)
+	a.compareList("supported_groups", original.SupportedGroups, emitted.SupportedGroups)
+	a.compareInts("ec_point_formats", original.ECPointFormats, emitted.ECPointFormats)
+	a.compareList("signature_algorithms", original.SignatureAlgorithms, emitted.SignatureAlgorithms)
+	a.compareValue("alpn", strings.Join(original.ALPN, ","), strings.Join(emitted.ALPN, ","))
+	a.compareList("supported_versions", original.SupportedVersions, emitted.SupportedVersions)
+	a.compareList("key_share_groups", original.KeyShareGroups, emitted.KeyShareGroups)
+	a.compareInts("psk_modes", original.PSKModes, emitted.PSKModes)
+
+	// Contents of remaining extensions (present in both)
+	emittedBodies := make(map[uint16][]byte)
+	for i, ext := range emitted.Extensions {
+		emittedBodies[ext] = emitted.ExtensionBodies[i]
+	}
+	for i, ext := range original.Extensions {
+		if IsGrease(ext) || volatileExtensions[ext] || parsedExtensions[ext] {
+			continue
+		}
+		body, ok := emittedBodies[ext]
+		if !ok || bytes.Equal(body, original.ExtensionBodies[i]) {
+			continue
+		}
+		a.Mismatches = append(a.Mismatches, Mismatch{
+			Field:    fmt.Sprintf("extension %d", ext),
+			Original: fmt.Sprintf("%x", original.ExtensionBodies[i]),
+			Emitted:  fmt.Sprintf("%x", body),
+			Details:  "contents differ",
+		})
+	}
+
+	a.Match = len(a.Mismatches) == 0
+	return a
+}
+
+func ja3Hash(ch *ClientHello) string {
+	_, hash := JA3(ch)
+	return hash
+}
+
+func (a *Audit) compareValue(field string, original, emitted interface{}) {
+	if original != emitted {
+		a.Mismatches = append(a.Mismatches, Mismatch{
+			Field:    field,
+			Original: fmt.Sprint(original),
+			Emitted:  fmt.Sprint(emitted),
+		})
+	}
+}
+
+func (a *Audit) compareInts(field string, original, emitted []int) {
+	a.compareValue(field, joinDecInts(original), joinDecInts(emitted))
+}
+
+// compareList compares lists with GREASE values normalized and describes the difference
+func (a *Audit) compareList(field string, original, emitted []uint16) {
+	original, emitted = normalizeGrease(original), normalizeGrease(emitted)
+	if equalLists(original, emitted) {
+		return
+	}
+	a.Mismatches = append(a.Mismatches, Mismatch{
+		Field:    field,
+		Original: joinHexAll(original),
+		Emitted:  joinHexAll(emitted),
+		Details:  describeListDiff(original, emitted),
+	})
+}
+
+func normalizeGrease(values []uint16) []uint16 {
+	res := make([]uint16, len(values))
+	for i, v := range values {
+		if IsGrease(v) {
+			v = greasePlaceholder
+		}
+		res[i] = v
+	}
+	return res
+}
+
+func equalLists(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func describeListDiff(original, emitted []uint16) string {
+	count := func(values []uint16) map[uint16]int {
+		res := make(map[uint16]int)
+		for _, v := range values {
+			res[v]++
+		}
+		return res
+	}
+	origCount, emittedCount := count(original), count(emitted)
+
+	var dropped, added []uint16
+	for _, v := range original {
+		if emittedCount[v] < origCount[v] {
+			dropped = append(dropped, v)
+			emittedCount[v]++
+		}
+	}
+	emittedCount = count(emitted)
+	for _, v := range emitted {
+		if origCount[v] < emittedCount[v] {
+			added = append(added, v)
+			origCount[v]++
+		}
+	}
+
+	var details []string
+	if len(dropped) > 0 {
+		details = append(details, "dropped "+joinHexAll(dropped))
+	}
+	if len(added) > 0 {
+		details = append(details, "added "+joinHexAll(added))
+	}
+	if len(details) == 0 {
+		details = append(details, "reordered")
+	}
+	return strings.Join(details, "; ")
+}
+
+// joinHexAll is like joinHex, but uses '-' separator to be readable in logs
+func joinHexAll(values []uint16) string {
+	return strings.ReplaceAll(joinHex(values), ",", "-")
+}
@@ -0,0 +1,161 @@
+package fingerprint
+
+import (
+	"reflect"
+	"testing"
+)
+
+func mustParseClientHello(t *testing.T, record []byte) *ClientHello {
+	t.Helper()
+	ch, err := ParseClientHello(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ch
+}
+
+func TestCompareGrease(t *testing.T) {
+	original := mustParseClientHello(t, chromeHello())
+	// Same hello with other GREASE values, as sent by another connection of the same client
+	emitted := mustParseClientHello(t, testClientHello(0x0303,
+		[]uint16{0xdada, 0x1301, 0x1302},
+		[]testExt{
+			{0x7a7a, nil},
+			sniExt("example.com"),
+			{extSupportedGroups, vec16(u16s(0xeaea, 0x001d))},
+			{extSupportedVersions, vec8(u16s(0x1a1a, 0x0304))},
+			{0xbaba, []byte{0}},
+		}))
+	reference := mustParseClientHello(t, testClientHello(0x0303,
+		[]uint16{0x2a2a, 0x1301, 0x1302},
+		[]testExt{
+			{0x4a4a, nil},
+			sniExt("example.com"),
+			{extSupportedGroups, vec16(u16s(0x0a0a, 0x001d))},
+			{extSupportedVersions, vec8(u16s(0x3a3a, 0x0304))},
+			{0x5a5a, []byte{0}},
+		}))
+
+	if a := Compare(reference, emitted); !a.Match {
+		t.Errorf("hellos differing only in GREASE values do not match: %v", a.Mismatches)
+	}
+	if a := Compare(original, original); !a.Match {
+		t.Errorf("hello does not match itself: %v", a.Mismatches)
+	}
+	if a := Compare(original, emitted); a.Match {
+		t.Error("different hellos match")
+	}
+}
+
+func TestCompareMismatches(t *testing.T) {
+	base := []testExt{
+		sniExt("example.com"),
+		{extSupportedGroups, vec16(u16s(0x001d, 0x0017))},
+		alpnExt("h2", "http/1.1"),
+		{0xff01, []byte{0}},
+	}
+	tests := []struct {
+		name    string
+		ciphers []uint16
+		exts    []testExt
+		want    []Mismatch
+	}{
+		{
+			name:    "same",
+			ciphers: []uint16{0x1301, 0x1302},
+			exts:    base,
+		},
+		{
+			name:    "dropped cipher",
+			ciphers: []uint16{0x1301},
+			exts:    base,
+			want: []Mismatch{
+				{Field: "cipher_suites", Original: "1301-1302", Emitted: "1301", Details: "dropped 1302"},
+			},
+		},
+		{
+			name:    "key share is volatile",
+			ciphers: []uint16{0x1301, 0x1302},
+			exts: append(append([]testExt(nil), base...),
+				testExt{extKeyShare, vec16(u16s(0x001d), vec16(make([]byte, 32)))}),
+			want: []Mismatch{
+				{Field: "extensions", Original: "0000-000a-0010-ff01", Emitted: "0000-000a-0010-ff01-0033",
+					Details: "added 0033"},
+				{Field: "key_share_groups", Original: "", Emitted: "001d", Details: "added 001d"},
+			},
+		},
+		{
+			name:    "different server name and alpn",
+			ciphers: []uint16{0x1301, 0x1302},
+			exts: []testExt{
+				sniExt("example.org"),
+				{extSupportedGroups, vec16(u16s(0x001d, 0x0017))},
+				alpnExt("http/1.1"),
+				{0xff01, []byte{0}},
+			},
+			want: []Mismatch{
+				{Field: "server_name", Original: "example.com", Emitted: "example.org"},
+				{Field: "alpn", Original: "h2,http/1.1", Emitted: "http/1.1"},
+			},
+		},
+		{
+			name:    "extension contents",
+			ciphers: []uint16{0x1301, 0x1302},
+			exts: []testExt{
+				sniExt("example.com"),
+				{extSupportedGroups, vec16(u16s(0x001d, 0x0017))},
+				alpnExt("h2", "http/1.1"),
+				{0xff01, []byte{1}},
+			},
+			want: []Mismatch{
+				{Field: "extension 65281", Original: "00", Emitted: "01", Details: "contents differ"},
+			},
+		},
+	}
+	original := mustParseClientHello(t, testClientHello(0x0303, []uint16{0x1301, 0x1302}, base))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			emitted := mustParseClientHello(t, testClientHello(0x0303, tt.ciphers, tt.exts))
+			a := Compare(original, emitted)
+			if !reflect.DeepEqual(a.Mismatches, tt.want) {
+				t.Errorf("mismatches = %+v, want %+v", a.Mismatches, tt.want)
+			}
+			if a.Match != (len(tt.want) == 0) {
+				t.Errorf("match = %v with %d mismatches", a.Match, len(tt.want))
+			}
+		})
+	}
+}
+
+func TestDescribeListDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		original []uint16
+		emitted  []uint16
+		want     string
+	}{
+		{"dropped", []uint16{1, 2, 3}, []uint16{1, 3}, "dropped 0002"},
+		{"added", []uint16{1, 3}, []uint16{1, 2, 3}, "added 0002"},
+		{"dropped and added", []uint16{1, 2, 3}, []uint16{4, 1, 3, 5}, "dropped 0002; added 0004-0005"},
+		{"reordered", []uint16{1, 2, 3}, []uint16{3, 1, 2}, "reordered"},
+		{"dropped duplicate", []uint16{1, 1, 2}, []uint16{1, 2}, "dropped 0001"},
+		{"added duplicate", []uint16{1, 2}, []uint16{2, 1, 2}, "added 0002"},
+		{"all dropped", []uint16{1, 2}, nil, "dropped 0001-0002"},
+		{"grease placeholder", []uint16{greasePlaceholder, 1}, []uint16{1}, "dropped 0a0a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := describeListDiff(tt.original, tt.emitted); got != tt.want {
+				t.Errorf("describeListDiff(%v, %v) = %q, want %q", tt.original, tt.emitted, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeGrease(t *testing.T) {
+	got := normalizeGrease([]uint16{0x1a1a, 0x1301, 0xfafa, 0x0a0b, 0x1a2a})
+	want := []uint16{greasePlaceholder, 0x1301, greasePlaceholder, 0x0a0b, 0x1a2a}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("normalizeGrease = %04x, want %04x", got, want)
+	}
+}
@@ -0,0 +1,360 @@
+package fingerprint
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TLS constants used by parser
+const (
+	recordTypeHandshake = 0x16
+	recordHeaderLen     = 5
+
+	typeClientHello       = 1
+	typeServerHello       = 2
+	typeServerKeyExchange = 12
+
+	curveTypeNamedCurve = 3
+
+	extServerName          = 0
+	extSupportedGroups     = 10
+	extECPointFormats      = 11
+	extSignatureAlgorithms = 13
+	extALPN                = 16
+	extSupportedVersions   = 43
+	extPSKModes            = 45
+	extKeyShare            = 51
+)
+
+// ClientHello holds parsed ClientHello fields in wire order.
+type ClientHello struct {
+	Raw []byte `json:"-"`
+
+	RecordVersion       uint16   `json:"record_version"`
+	Version             uint16   `json:"version"`
+	SessionIDLength     int      `json:"session_id_length"`
+	CipherSuites        []uint16 `json:"cipher_suites"`
+	CompressionMethods  []int    `json:"compression_methods"`
+	Extensions          []uint16 `json:"extensions"`
+	ServerName          string   `json:"server_name,omitempty"`
+	SupportedGroups     []uint16 `json:"supported_groups,omitempty"`
+	ECPointFormats      []int    `json:"ec_point_formats,omitempty"`
+	SignatureAlgorithms []uint16 `json:"signature_algorithms,omitempty"`
+	ALPN                []string `json:"alpn,omitempty"`
+	SupportedVersions   []uint16 `json:"supported_versions,omitempty"`
+	KeyShareGroups      []uint16 `json:"key_share_groups,omitempty"`
+	PSKModes            []int    `json:"psk_modes,omitempty"`
+	Grease              Grease   `json:"grease"`
+
+	// ExtensionBodies holds raw extension data, in the same order as Extensions
+	ExtensionBodies [][]byte `json:"-"`
+}
+
+// Grease holds positions (indexes) of GREASE values in corresponding ClientHello lists.
+type Grease struct {
+	CipherSuites      []int `json:"cipher_suites,omitempty"`
+	Extensions        []int `json:"extensions,omitempty"`
+	SupportedGroups   []int `json:"supported_groups,omitempty"`
+	SupportedVersions []int `json:"supported_versions,omitempty"`
+	KeyShareGroups    []int `json:"key_share_groups,omitempty"`
+}
+
+// ServerHello holds parsed ServerHello fields.
+type ServerHello struct {
+	Raw []byte `json:"-"`
+
+	Version           uint16   `json:"version"`
+	CipherSuite       uint16   `json:"cipher_suite"`
+	Extensions        []uint16 `json:"extensions"`
+	SupportedVersion  uint16   `json:"supported_version,omitempty"`
+	ALPN              string   `json:"alpn,omitempty"`
+	KeyShareGroup     uint16   `json:"key_share_group,omitempty"`
+	CompressionMethod int      `json:"compression_method"`
+}
+
+// IsGrease reports whether v is a GREASE value (RFC 8701).
+func IsGrease(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// reader is a minimal bounds-checked TLS wire format reader
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n > len(r.data) {
+		r.err = fmt.Errorf("message truncated")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) u8() int {
+	b := r.bytes(1)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])
+}
+
+func (r *reader) u16() uint16 {
+	b := r.bytes(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *reader) u24() int {
+	b := r.bytes(3)
+	if b == nil {
+		return 0
+	}
+	return int(b[0])<<16 | int(b[1])<<8 | int(b[2])
+}
+
+// vec8 and vec16 read length-prefixed vectors
+func (r *reader) vec8() *reader {
+	return &reader{data: r.bytes(r.u8()), err: r.err}
+}
+
+func (r *reader) vec16() *reader {
+	return &reader{data: r.bytes(int(r.u16())), err: r.err}
+}
+
+func (r *reader) empty() bool {
+	return len(r.data) == 0
+}
+
+func (r *reader) u16List() []uint16 {
+	var res []uint16
+	for !r.empty() && r.err == nil {
+		res = append(res, r.u16())
+	}
+	return res
+}
+
+func (r *reader) u8List() []int {
+	var res []int
+	for !r.empty() && r.err == nil {
+		res = append(res, r.u8())
+	}
+	return res
+}
+
+// handshakeMessage extracts handshake message of given type from TLS record(s).
+// Message may span several records.
+func handshakeMessage(raw []byte, msgType int) (recordVersion uint16, body []byte, err error) {
+	var payload []byte
+	r := &reader{data: raw}
+	for !r.empty() {
+		if r.u8() != recordTypeHandshake {
+			return 0, nil, fmt.Errorf("not a handshake record")
+		}
+		version := r.u16()
+		if recordVersion == 0 {
+			recordVersion = version
+		}
+		payload = append(payload, r.vec16().data...)
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		if len(payload) < 4 {
+			continue
+		}
+		msgLen := int(payload[1])<<16 | int(payload[2])<<8 | int(payload[3])
+		if len(payload) >= 4+msgLen {
+			break
+		}
+	}
+	m := &reader{data: payload}
+	if t := m.u8(); t != msgType {
+		return 0, nil, fmt.Errorf("unexpected handshake message type %d", t)
+	}
+	body = m.bytes(m.u24())
+	return recordVersion, body, m.err
+}
+
+// ParseClientHello parses ClientHello from raw TLS record(s) (starting from record header).
+func ParseClientHello(raw []byte) (*ClientHello, error) {
+	recordVersion, body, err := handshakeMessage(raw, typeClientHello)
+	if err != nil {
+		return nil, fmt.Errorf("ClientHello: %v", err)
+	}
+	ch := &ClientHello{Raw: raw, RecordVersion: recordVersion}
+	r := &reader{data: body}
+	ch.Version = r.u16()
+	r.bytes(32) // random
+	ch.SessionIDLength = len(r.vec8().data)
+	ch.CipherSuites = r.vec16().u16List()
+	ch.CompressionMethods = r.vec8().u8List()
+	for i, c := range ch.CipherSuites {
+		if IsGrease(c) {
+			ch.Grease.CipherSuites = append(ch.Grease.CipherSuites, i)
+		}
+	}
+	if r.empty() {
+		return ch, r.err
+	}
+
+	exts := r.vec16()
+	for !exts.empty() && exts.err == nil {
+		extType := exts.u16()
+		extData := exts.vec16()
+		if exts.err != nil {
+			break
+		}
+		if IsGrease(extType) {
+			ch.Grease.Extensions = append(ch.Grease.Extensions, len(ch.Extensions))
+		}
+		ch.Extensions = append(ch.Extensions, extType)
+		ch.ExtensionBodies = append(ch.ExtensionBodies, extData.data)
+		ch.parseExtension(extType, extData)
+	}
+	if exts.err != nil {
+		return nil, fmt.Errorf("ClientHello extensions: %v", exts.err)
+	}
+	return ch, r.err
+}
+
+func (ch *ClientHello) parseExtension(extType uint16, r *reader) {
+	switch extType {
+	case extServerName:
+		names := r.vec16()
+		for !names.empty() && names.err == nil {
+			nameType := names.u8()
+			name := names.vec16()
+			if nameType == 0 {
+				ch.ServerName = string(name.data)
+			}
+		}
+	case extSupportedGroups:
+		ch.SupportedGroups = r.vec16().u16List()
+		ch.Grease.SupportedGroups = greasePositions(ch.SupportedGroups)
+	case extECPointFormats:
+		ch.ECPointFormats = r.vec8().u8List()
+	case extSignatureAlgorithms:
+		ch.SignatureAlgorithms = r.vec16().u16List()
+	case extALPN:
+		protos := r.vec16()
+		for !protos.empty() && protos.err == nil {
+			ch.ALPN = append(ch.ALPN, string(protos.vec8().data))
+		}
+	case extSupportedVersions:
+		ch.SupportedVersions = r.vec8().u16List()
+		ch.Grease.SupportedVersions = greasePositions(ch.SupportedVersions)
+	case extKeyShare:
+		shares := r.vec16()
+		for !shares.empty() && shares.err == nil {
+			ch.KeyShareGroups = append(ch.KeyShareGroups, shares.u16())
+			shares.vec16()
+		}
+		ch.Grease.KeyShareGroups = greasePositions(ch.KeyShareGroups)
+	case extPSKModes:
+		ch.PSKModes = r.vec8().u8List()
+	}
+}
+
+func greasePositions(values []uint16) []int {
+	var res []int
+	for i, v := range values {
+		if IsGrease(v) {
+			res = append(res, i)
+		}
+	}
+	return res
+}
+
+// ParseServerHello parses ServerHello from raw TLS record(s) (starting from record header).
+func ParseServerHello(raw []byte) (*ServerHello, error) {
+	_, body, err := handshakeMessage(raw, typeServerHello)
+	if err != nil {
+		return nil, fmt.Errorf("ServerHello: %v", err)
+	}
+	return parseServerHelloBody(raw, body)
+}
+
+func parseServerHelloBody(raw, body []byte) (*ServerHello, error) {
+	sh := &ServerHello{Raw: raw}
+	r := &reader{data: body}
+	sh.Version = r.u16()
+	r.bytes(32) // random
+	r.vec8()    // session ID
+	sh.CipherSuite = r.u16()
+	sh.CompressionMethod = r.u8()
+	if r.empty() {
+		return sh, r.err
+	}
+
+	exts := r.vec16()
+	for !exts.empty() && exts.err == nil {
+		extType := exts.u16()
+		extData := exts.vec16()
+		if exts.err != nil {
+			break
+		}
+		sh.Extensions = append(sh.Extensions, extType)
+		switch extType {
+		case extSupportedVersions:
+			sh.SupportedVersion = extData.u16()
+		case extALPN:
+			sh.ALPN = string(extData.vec16().vec8().data)
+		case extKeyShare:
+			sh.KeyShareGroup = extData.u16()
+		}
+	}
+	if exts.err != nil {
+		return nil, fmt.Errorf("ServerHello extensions: %v", exts.err)
+	}
+	return sh, r.err
+}
+
+// ServerGroup returns key exchange group chosen by server: key share group of TLS 1.3 ServerHello
+// or named curve of TLS 1.2 ECDHE ServerKeyExchange. raw is server handshake data starting with ServerHello.
+func ServerGroup(raw []byte) (uint16, bool) {
+	// Collect plaintext handshake messages
+	var payload []byte
+	r := &reader{data: raw}
+	for !r.empty() && r.err == nil {
+		if r.u8() != recordTypeHandshake {
+			break
+		}
+		r.u16() // version
+		data := r.vec16()
+		if r.err != nil {
+			break
+		}
+		payload = append(payload, data.data...)
+	}
+
+	m := &reader{data: payload}
+	for !m.empty() {
+		msgType := m.u8()
+		body := m.bytes(m.u24())
+		if m.err != nil {
+			return 0, false
+		}
+		switch msgType {
+		case typeServerHello:
+			sh, err := parseServerHelloBody(raw, body)
+			if err == nil && sh.KeyShareGroup != 0 {
+				return sh.KeyShareGroup, true
+			}
+		case typeServerKeyExchange:
+			ske := &reader{data: body}
+			if ske.u8() != curveTypeNamedCurve {
+				return 0, false
+			}
+			group := ske.u16()
+			return group, ske.err == nil
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,422 @@
+package fingerprint
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+type testExt struct {
+	typ  uint16
+	body []byte
+}
+
+func u16s(values ...uint16) []byte {
+	b := make([]byte, 0, 2*len(values))
+	for _, v := range values {
+		b = binary.BigEndian.AppendUint16(b, v)
+	}
+	return b
+}
+
+func vec8(parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	return append([]byte{byte(len(body))}, body...)
+}
+
+func vec16(parts ...[]byte) []byte {
+	body := bytes.Join(parts, nil)
+	return append(u16s(uint16(len(body))), body...)
+}
+
+func handshakeRecord(msgType byte, body []byte) []byte {
+	msg := append([]byte{msgType, byte(len(body) >> 16), byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{recordTypeHandshake, 0x03, 0x01}, vec16(msg)...)
+}
+
+func extensionsBlock(exts []testExt) []byte {
+	var b []byte
+	for _, e := range exts {
+		b = append(b, u16s(e.typ)...)
+		b = append(b, vec16(e.body)...)
+	}
+	return vec16(b)
+}
+
+// testClientHello builds ClientHello record, exts == nil omits extensions block
+func testClientHello(version uint16, ciphers []uint16, exts []testExt) []byte {
+	body := u16s(version)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, vec8(make([]byte, 32))...)
+	body = append(body, vec16(u16s(ciphers...))...)
+	body = append(body, vec8([]byte{0})...)
+	if exts != nil {
+		body = append(body, extensionsBlock(exts)...)
+	}
+	return handshakeRecord(typeClientHello, body)
+}
+
+func testServerHello(version, cipher uint16, exts []testExt) []byte {
+	body := u16s(version)
+	body = append(body, make([]byte, 32)...) // random
+	body = append(body, vec8(make([]byte, 32))...)
+	body = append(body, u16s(cipher)...)
+	body = append(body, 0)
+	if exts != nil {
+		body = append(body, extensionsBlock(exts)...)
+	}
+	return handshakeRecord(typeServerHello, body)
+}
+
+func sniExt(name string) testExt {
+	return testExt{extServerName, vec16([]byte{0}, vec16([]byte(name)))}
+}
+
+func alpnExt(protos ...string) testExt {
+	var list [][]byte
+	for _, p := range protos {
+		list = append(list, vec8([]byte(p)))
+	}
+	return testExt{extALPN, vec16(list...)}
+}
+
+// chromeHello is ClientHello from JA4 specification example (with GREASE added)
+func chromeHello() []byte {
+	return testClientHello(0x0303,
+		[]uint16{0x1a1a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014,
+			0x009c, 0x009d, 0x002f, 0x0035},
+		[]testExt{
+			{0x2a2a, nil},
+			sniExt("example.com"),
+			{0x0017, nil},
+			{0xff01, []byte{0}},
+			{extSupportedGroups, vec16(u16s(0x3a3a, 0x001d, 0x0017, 0x0018))},
+			{extECPointFormats, vec8([]byte{0})},
+			{0x0023, nil},
+			alpnExt("h2", "http/1.1"),
+			{0x0005, []byte{1, 0, 0, 0, 0}},
+			{extSignatureAlgorithms, vec16(u16s(0x0403, 0x0804, 0x0401, 0x0503, 0x0805, 0x0501, 0x0806, 0x0601))},
+			{0x0012, nil},
+			{extKeyShare, vec16(u16s(0x3a3a), vec16([]byte{0}), u16s(0x001d), vec16(make([]byte, 32)))},
+			{extPSKModes, vec8([]byte{1})},
+			{extSupportedVersions, vec8(u16s(0x5a5a, 0x0304, 0x0303))},
+			{0x001b, []byte{2, 0, 2}},
+			{0x4469, nil},
+			{0x0015, make([]byte, 8)},
+			{0x4a4a, []byte{0}},
+		})
+}
+
+func TestParseClientHello(t *testing.T) {
+	ch, err := ParseClientHello(chromeHello())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &ClientHello{
+		RecordVersion:      0x0301,
+		Version:            0x0303,
+		SessionIDLength:    32,
+		CompressionMethods: []int{0},
+		ServerName:         "example.com",
+		SupportedGroups:    []uint16{0x3a3a, 0x001d, 0x0017, 0x0018},
+		ECPointFormats:     []int{0},
+		ALPN:               []string{"h2", "http/1.1"},
+		SupportedVersions:  []uint16{0x5a5a, 0x0304, 0x0303},
+		KeyShareGroups:     []uint16{0x3a3a, 0x001d},
+		PSKModes:           []int{1},
+		Grease: Grease{
+			CipherSuites:      []int{0},
+			Extensions:        []int{0, 17},
+			SupportedGroups:   []int{0},
+			SupportedVersions: []int{0},
+			KeyShareGroups:    []int{0},
+		},
+	}
+	got := *ch
+	got.Raw, got.CipherSuites, got.Extensions, got.SignatureAlgorithms, got.ExtensionBodies = nil, nil, nil, nil, nil
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("got %+v\nwant %+v", got, *want)
+	}
+	if len(ch.CipherSuites) != 16 || len(ch.Extensions) != 18 || len(ch.ExtensionBodies) != 18 {
+		t.Errorf("got %d ciphers, %d extensions, %d bodies", len(ch.CipherSuites), len(ch.Extensions), len(ch.ExtensionBodies))
+	}
+}
+
+// paddedHello returns ClientHello with handshake message body of exactly bodyLen bytes
+func paddedHello(bodyLen int) []byte {
+	exts := []testExt{sniExt("example.com"), {0x0015, nil}}
+	unpadded := len(testClientHello(0x0303, []uint16{0x1301}, exts)) - recordHeaderLen - 4
+	exts[1].body = make([]byte, bodyLen-unpadded)
+	return testClientHello(0x0303, []uint16{0x1301}, exts)
+}
+
+func TestParseClientHelloSplitRecords(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    []byte
+		splits []int // record boundaries in handshake message
+	}{
+		{"chrome", chromeHello(), []int{3, 100}},
+		// 24-bit length with non-zero low bits, last but one record ends right before message end
+		{"body 0x1fc", paddedHello(0x1fc), []int{200, 4 + 0x1fc - 4}},
+		{"body 0x1fc single byte tail", paddedHello(0x1fc), []int{2, 4 + 0x1fc - 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := tt.raw[recordHeaderLen:]
+			var split []byte
+			prev := 0
+			for _, end := range append(tt.splits, len(msg)) {
+				split = append(split, recordTypeHandshake, 0x03, 0x01)
+				split = append(split, vec16(msg[prev:end])...)
+				prev = end
+			}
+			ch, err := ParseClientHello(split)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := ParseClientHello(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ch.ServerName != "example.com" || !reflect.DeepEqual(ch.Extensions, want.Extensions) {
+				t.Errorf("got server name %q, extensions %v, want %v", ch.ServerName, ch.Extensions, want.Extensions)
+			}
+		})
+	}
+}
+
+func TestJA3(t *testing.T) {
+	// Examples from https://github.com/salesforce/ja3
+	tests := []struct {
+		name    string
+		raw     []byte
+		ja3     string
+		ja3Hash string
+	}{
+		{
+			name: "extensions",
+			raw: testClientHello(0x0301,
+				[]uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				[]testExt{
+					sniExt("example.com"),
+					{extSupportedGroups, vec16(u16s(23, 24, 25))},
+					{extECPointFormats, vec8([]byte{0})},
+				}),
+			ja3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash: "ada70206e40642a3e4461f35503241d5",
+		},
+		{
+			name:    "no extensions",
+			raw:     testClientHello(0x0301, []uint16{4, 5, 10, 9, 100, 98, 3, 6, 19, 18, 99}, nil),
+			ja3:     "769,4-5-10-9-100-98-3-6-19-18-99,,,",
+			ja3Hash: "de350869b8c85de67a350c8d186f11e6",
+		},
+		{
+			name: "GREASE is ignored",
+			raw: testClientHello(0x0301,
+				[]uint16{0xdada, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+				[]testExt{
+					{0xfafa, nil},
+					sniExt("example.com"),
+					{extSupportedGroups, vec16(u16s(0x0a0a, 23, 24, 25))},
+					{extECPointFormats, vec8([]byte{0})},
+				}),
+			ja3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash: "ada70206e40642a3e4461f35503241d5",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := ParseClientHello(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s, hash := JA3(ch)
+			if s != tt.ja3 || hash != tt.ja3Hash {
+				t.Errorf("JA3 = %s %s, want %s %s", s, hash, tt.ja3, tt.ja3Hash)
+			}
+		})
+	}
+}
+
+func TestJA3N(t *testing.T) {
+	ch, err := ParseClientHello(testClientHello(0x0303, []uint16{0x1301},
+		[]testExt{{extSupportedGroups, vec16(u16s(29))}, sniExt("a"), {0x0017, nil}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := JA3(ch); s != "771,4865,10-0-23,29," {
+		t.Errorf("JA3 = %s", s)
+	}
+	if s, _ := JA3N(ch); s != "771,4865,0-10-23,29," {
+		t.Errorf("JA3N = %s", s)
+	}
+}
+
+func TestJA4(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		want string
+	}{
+		{
+			// https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4.md
+			name: "specification example",
+			raw:  chromeHello(),
+			want: "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		{
+			name: "no SNI, ALPN and extensions",
+			raw:  testClientHello(0x0303, []uint16{0xc02f}, nil),
+			want: "t12i010000_" + truncatedHash("c02f", false) + "_000000000000",
+		},
+		{
+			name: "non-alphanumeric ALPN",
+			raw:  testClientHello(0x0303, []uint16{0xc02f}, []testExt{alpnExt("\xab\xcd")}),
+			want: "t12i0101ad_" + truncatedHash("c02f", false) + "_000000000000",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ch, err := ParseClientHello(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := JA4(ch); got != tt.want {
+				t.Errorf("JA4 = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJA3SAndJA4S(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+		ja3S string
+		ja4S string
+	}{
+		{
+			// https://github.com/FoxIO-LLC/ja4/blob/main/technical_details/JA4S.md
+			name: "TLS 1.3",
+			raw: testServerHello(0x0303, 0x1301, []testExt{
+				{extKeyShare, append(u16s(0x001d), vec16(make([]byte, 32))...)},
+				{extSupportedVersions, u16s(0x0304)},
+			}),
+			ja3S: "771,4865,51-43",
+			ja4S: "t130200_1301_234ea6891581",
+		},
+		{
+			name: "TLS 1.2 with ALPN",
+			raw: testServerHello(0x0303, 0xc02f, []testExt{
+				{0xff01, []byte{0}},
+				alpnExt("h2"),
+				{extECPointFormats, vec8([]byte{0})},
+			}),
+			ja3S: "771,49199,65281-16-11",
+			ja4S: "t1203h2_c02f_" + truncatedHash("ff01,0010,000b", false),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sh, err := ParseServerHello(tt.raw)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if s, hash := JA3S(sh); s != tt.ja3S || hash != md5Hex(tt.ja3S) {
+				t.Errorf("JA3S = %s %s, want %s", s, hash, tt.ja3S)
+			}
+			if got := JA4S(sh); got != tt.ja4S {
+				t.Errorf("JA4S = %s, want %s", got, tt.ja4S)
+			}
+		})
+	}
+}
+
+func TestParseClientHelloMalformed(t *testing.T) {
+	valid := chromeHello()
+	withBadExtLen := append([]byte(nil), valid...)
+	// Extensions block length is right after single compression method
+	extLenPos := recordHeaderLen + 4 + 2 + 32 + 33 + 2 + 2*16 + 2
+	binary.BigEndian.PutUint16(withBadExtLen[extLenPos:], 0xffff)
+	// Extension claiming more data than extensions block holds
+	withBadExt := testClientHello(0x0303, []uint16{0x1301}, []testExt{{extServerName, []byte{0, 1}}})
+	binary.BigEndian.PutUint16(withBadExt[len(withBadExt)-4:], 10)
+
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"empty", nil},
+		{"not handshake", append([]byte{0x17}, valid[1:]...)},
+		{"ServerHello", testServerHello(0x0303, 0x1301, nil)},
+		{"record length beyond data", append(valid[:3:3], 0xff, 0xff)},
+		{"extensions length beyond data", withBadExtLen},
+		{"extension length beyond block", withBadExt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseClientHello(tt.raw); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+// Every truncation of valid messages must fail without panic
+func TestParseTruncated(t *testing.T) {
+	ch := chromeHello()
+	for n := 0; n < len(ch); n++ {
+		if _, err := ParseClientHello(ch[:n]); err == nil {
+			t.Errorf("ClientHello truncated to %d bytes: no error", n)
+		}
+	}
+	sh := testServerHello(0x0303, 0x1301, []testExt{{extSupportedVersions, u16s(0x0304)}, alpnExt("h2")})
+	for n := 0; n < len(sh); n++ {
+		if _, err := ParseServerHello(sh[:n]); err == nil {
+			t.Errorf("ServerHello truncated to %d bytes: no error", n)
+		}
+		ServerGroup(sh[:n])
+	}
+}
+
+// Corrupted length fields must not cause panics
+func TestParseCorrupted(t *testing.T) {
+	for _, raw := range [][]byte{
+		chromeHello(),
+		testServerHello(0x0303, 0x1301, []testExt{{extSupportedVersions, u16s(0x0304)}, alpnExt("h2")}),
+	} {
+		for i := range raw {
+			for _, v := range []byte{0x00, 0x01, 0x7f, 0xff} {
+				corrupted := append([]byte(nil), raw...)
+				corrupted[i] = v
+				if ch, err := ParseClientHello(corrupted); err == nil {
+					JA3(ch)
+					JA4(ch)
+				}
+				if sh, err := ParseServerHello(corrupted); err == nil {
+					JA3S(sh)
+					JA4S(sh)
+				}
+				ServerGroup(corrupted)
+			}
+		}
+	}
+}
+
+func FuzzParseClientHello(f *testing.F) {
+	f.Add(chromeHello())
+	f.Add(testServerHello(0x0303, 0x1301, []testExt{{extSupportedVersions, u16s(0x0304)}}))
+	f.Fuzz(func(t *testing.T, raw []byte) {
+		if ch, err := ParseClientHello(raw); err == nil {
+			JA3(ch)
+			JA4(ch)
+		}
+		if sh, err := ParseServerHello(raw); err == nil {
+			JA4S(sh)
+		}
+		ServerGroup(raw)
+	})
+}
@@ -0,0 +1,213 @@
+package fingerprint
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"golang.org/x/net/http2/hpack"
+	"strconv"
+	"strings"
+)
+
+// HTTP/2 constants used by parser
+const (
+	http2Preface        = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+	http2FrameHeaderLen = 9
+
+	frameHeaders      = 0x1
+	framePriority     = 0x2
+	frameSettings     = 0x4
+	frameWindowUpdate = 0x8
+	frameContinuation = 0x9
+
+	flagAck        = 0x1
+	flagEndHeaders = 0x4
+	flagPadded     = 0x8
+	flagPriority   = 0x20
+)
+
+// ErrHTTP2Incomplete is returned by ParseHTTP2 if data ends before first request headers.
+var ErrHTTP2Incomplete = errors.New("incomplete HTTP/2 data")
+
+// HTTP2 holds client HTTP/2 connection parameters sent before (and with) first request.
+type HTTP2 struct {
+	Settings      []HTTP2Setting  `json:"settings"`
+	WindowUpdate  uint32          `json:"window_update,omitempty"` // connection window increment
+	Priorities    []HTTP2Priority `json:"priorities,omitempty"`
+	PseudoHeaders []string        `json:"pseudo_headers"` // in order, without colon
+}
+
+type HTTP2Setting struct {
+	ID    uint16 `json:"id"`
+	Value uint32 `json:"value"`
+}
+
+// HTTP2Priority is PRIORITY frame. Weight is the actual one (1-256), not the wire value.
+type HTTP2Priority struct {
+	StreamID  uint32 `json:"stream_id"`
+	Exclusive bool   `json:"exclusive"`
+	DependsOn uint32 `json:"depends_on"`
+	Weight    int    `json:"weight"`
+}
+
+// ParseHTTP2 parses client HTTP/2 connection start (starting from preface) up to the end of first
+// request headers. ErrHTTP2Incomplete is returned if data ends earlier.
+func ParseHTTP2(data []byte) (*HTTP2, error) {
+	if len(data) < len(http2Preface) {
+		if strings.HasPrefix(http2Preface, string(data)) {
+			return nil, ErrHTTP2Incomplete
+		}
+		return nil, fmt.Errorf("not an HTTP/2 connection")
+	}
+	if string(data[:len(http2Preface)]) != http2Preface {
+		return nil, fmt.Errorf("not an HTTP/2 connection")
+	}
+	data = data[len(http2Preface):]
+
+	h := &HTTP2{}
+	var headerBlock []byte
+	for {
+		if len(data) < http2FrameHeaderLen {
+			return nil, ErrHTTP2Incomplete
+		}
+		length := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		frameType, flags := data[3], data[4]
+		streamID := binary.BigEndian.Uint32(data[5:9]) & (1<<31 - 1)
+		if len(data) < http2FrameHeaderLen+length {
+			return nil, ErrHTTP2Incomplete
+		}
+		payload := data[http2FrameHeaderLen : http2FrameHeaderLen+length]
+		data = data[http2FrameHeaderLen+length:]
+
+		switch frameType {
+		case frameSettings:
+			if flags&flagAck != 0 {
+				continue
+			}
+			if len(payload)%6 != 0 {
+				return nil, fmt.Errorf("malformed SETTINGS frame")
+			}
+			for i := 0; i < len(payload); i += 6 {
+				h.Settings = append(h.Settings, HTTP2Setting{
+					ID:    binary.BigEndian.Uint16(payload[i:]),
+					Value: binary.BigEndian.Uint32(payload[i+2:]),
+				})
+			}
+		case frameWindowUpdate:
+			if len(payload) != 4 {
+				return nil, fmt.Errorf("malformed WINDOW_UPDATE frame")
+			}
+			if streamID == 0 && h.WindowUpdate == 0 {
+				h.WindowUpdate = binary.BigEndian.Uint32(payload) & (1<<31 - 1)
+			}
+		case framePriority:
+			if len(payload) != 5 {
+				return nil, fmt.Errorf("malformed PRIORITY frame")
+			}
+			h.Priorities = append(h.Priorities, parsePriority(streamID, payload))
+		case frameHeaders:
+			fragment, err := headersFragment(payload, flags)
+			if err != nil {
+				return nil, err
+			}
+			headerBlock = append(headerBlock, fragment...)
+			if flags&flagEndHeaders != 0 {
+				return h, h.setPseudoHeaders(headerBlock)
+			}
+		case frameContinuation:
+			if headerBlock == nil {
+				return nil, fmt.Errorf("unexpected CONTINUATION frame")
+			}
+			headerBlock = append(headerBlock, payload...)
+			if flags&flagEndHeaders != 0 {
+				return h, h.setPseudoHeaders(headerBlock)
+			}
+		}
+	}
+}
+
+func parsePriority(streamID uint32, p []byte) HTTP2Priority {
+	dep := binary.BigEndian.Uint32(p)
+	return HTTP2Priority{
+		StreamID:  streamID,
+		Exclusive: dep>>31 == 1,
+		DependsOn: dep & (1<<31 - 1),
+		Weight:    int(p[4]) + 1,
+	}
+}
+
+// headersFragment strips padding and priority fields from HEADERS frame payload
+func headersFragment(p []byte, flags byte) ([]byte, error) {
+	padding := 0
+	if flags&flagPadded != 0 {
+		if len(p) < 1 {
+			return nil, fmt.Errorf("malformed HEADERS frame")
+		}
+		padding = int(p[0])
+		p = p[1:]
+	}
+	if flags&flagPriority != 0 {
+		if len(p) < 5 {
+			return nil, fmt.Errorf("malformed HEADERS frame")
+		}
+		p = p[5:]
+	}
+	if padding > len(p) {
+		return nil, fmt.Errorf("malformed HEADERS frame")
+	}
+	return p[:len(p)-padding], nil
+}
+
+func (h *HTTP2) setPseudoHeaders(block []byte) error {
+	// Client can not use dynamic table larger than default before server's SETTINGS are acknowledged
+	decoder := hpack.NewDecoder(4096, nil)
+	fields, err := decoder.DecodeFull(block)
+	if err != nil {
+		return fmt.Errorf("decoding headers: %v", err)
+	}
+	for _, f := range fields {
+		if f.IsPseudo() {
+			h.PseudoHeaders = append(h.PseudoHeaders, strings.TrimPrefix(f.Name, ":"))
+		}
+	}
+	return nil
+}
+
+// Akamai returns HTTP/2 fingerprint string in Akamai format
+// (https://www.blackhat.com/docs/eu-17/materials/eu-17-Shuster-Passive-Fingerprinting-Of-HTTP2-Clients-wp.pdf)
+// and its MD5 hash.
+func Akamai(h *HTTP2) (string, string) {
+	settings := make([]string, 0, len(h.Settings))
+	for _, s := range h.Settings {
+		settings = append(settings, fmt.Sprintf("%d:%d", s.ID, s.Value))
+	}
+	windowUpdate := "00"
+	if h.WindowUpdate != 0 {
+		windowUpdate = strconv.FormatUint(uint64(h.WindowUpdate), 10)
+	}
+	priorities := "0"
+	if len(h.Priorities) > 0 {
+		list := make([]string, 0, len(h.Priorities))
+		for _, p := range h.Priorities {
+			exclusive := 0
+			if p.Exclusive {
+				exclusive = 1
+			}
+			list = append(list, fmt.Sprintf("%d:%d:%d:%d", p.StreamID, exclusive, p.DependsOn, p.Weight))
+		}
+		priorities = strings.Join(list, ",")
+	}
+	pseudoHeaders := make([]string, 0, len(h.PseudoHeaders))
+	for _, name := range h.PseudoHeaders {
+		if name != "" {
+			pseudoHeaders = append(pseudoHeaders, name[:1])
+		}
+	}
+	s := strings.Join([]string{
+		strings.Join(settings, ";"),
+		windowUpdate,
+		priorities,
+		strings.Join(pseudoHeaders, ","),
+	}, "|")
+	return s, md5Hex(s)
+}
@@ -0,0 +1,180 @@
+package fingerprint
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// JA3 returns JA3 string and its MD5 hash (https://github.com/salesforce/ja3).
+func JA3(ch *ClientHello) (string, string) {
+	return ja3(ch, ch.Extensions)
+}
+
+// JA3N returns normalized JA3 (extensions sorted, so that extension order randomization
+// does not change it) and its MD5 hash.
+func JA3N(ch *ClientHello) (string, string) {
+	exts := append([]uint16(nil), ch.Extensions...)
+	sort.Slice(exts, func(i, j int) bool { return exts[i] < exts[j] })
+	return ja3(ch, exts)
+}
+
+func ja3(ch *ClientHello, exts []uint16) (string, string) {
+	s := strings.Join([]string{
+		strconv.Itoa(int(ch.Version)),
+		joinDec(ch.CipherSuites),
+		joinDec(exts),
+		joinDec(ch.SupportedGroups),
+		joinDecInts(ch.ECPointFormats),
+	}, ",")
+	return s, md5Hex(s)
+}
+
+// JA3S returns JA3S string and its MD5 hash.
+func JA3S(sh *ServerHello) (string, string) {
+	s := strings.Join([]string{
+		strconv.Itoa(int(sh.Version)),
+		strconv.Itoa(int(sh.CipherSuite)),
+		joinDec(sh.Extensions),
+	}, ",")
+	return s, md5Hex(s)
+}
+
+// JA4 returns JA4 fingerprint (https://github.com/FoxIO-LLC/ja4) of TLS over TCP ClientHello.
+func JA4(ch *ClientHello) string {
+	version := ch.Version
+	for _, v := range ch.SupportedVersions {
+		if !IsGrease(v) && v > version {
+			version = v
+		}
+	}
+	sni := "i"
+	if ch.ServerName != "" {
+		sni = "d"
+	}
+	ciphers := withoutGrease(ch.CipherSuites)
+	exts := withoutGrease(ch.Extensions)
+	alpn := ""
+	if len(ch.ALPN) > 0 {
+		alpn = ch.ALPN[0]
+	}
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min(len(ciphers), 99), min(len(exts), 99), ja4ALPN(alpn))
+
+	sortedCiphers := sortedCopy(ciphers)
+	b := truncatedHash(joinHex(sortedCiphers), len(sortedCiphers) == 0)
+
+	var hashedExts []uint16
+	for _, e := range sortedCopy(exts) {
+		if e != extServerName && e != extALPN {
+			hashedExts = append(hashedExts, e)
+		}
+	}
+	c := joinHex(hashedExts)
+	sigAlgs := withoutGrease(ch.SignatureAlgorithms)
+	if len(sigAlgs) > 0 {
+		c += "_" + joinHex(sigAlgs)
+	}
+	return a + "_" + b + "_" + truncatedHash(c, len(hashedExts) == 0)
+}
+
+// JA4S returns JA4S fingerprint of ServerHello.
+func JA4S(sh *ServerHello) string {
+	version := sh.Version
+	if sh.SupportedVersion != 0 {
+		version = sh.SupportedVersion
+	}
+	a := fmt.Sprintf("t%s%02d%s", ja4Version(version), min(len(sh.Extensions), 99), ja4ALPN(sh.ALPN))
+	b := fmt.Sprintf("%04x", sh.CipherSuite)
+	return a + "_" + b + "_" + truncatedHash(joinHex(sh.Extensions), len(sh.Extensions) == 0)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	default:
+		return "00"
+	}
+}
+
+func ja4ALPN(alpn string) string {
+	if alpn == "" {
+		return "00"
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if !isAlnum(first) || !isAlnum(last) {
+		return fmt.Sprintf("%x%x", first>>4, last&0x0f)
+	}
+	return string([]byte{first, last})
+}
+
+func isAlnum(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func truncatedHash(s string, empty bool) string {
+	if empty {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
+
+func md5Hex(s string) string {
+	sum := md5.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func withoutGrease(values []uint16) []uint16 {
+	res := make([]uint16, 0, len(values))
+	for _, v := range values {
+		if !IsGrease(v) {
+			res = append(res, v)
+		}
+	}
+	return res
+}
+
+func sortedCopy(values []uint16) []uint16 {
+	res := append([]uint16(nil), values...)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func joinDec(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if !IsGrease(v) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinDecInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, "-")
+}
+
+func joinHex(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%04x", v)
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,80 @@
+package fingerprint
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Record is a per-connection fingerprint report.
+type Record struct {
+	Time   time.Time `json:"time"`
+	Client string    `json:"client"`
+	Target string    `json:"target"`
+
+	ClientHello *ClientHello `json:"client_hello,omitempty"`
+	JA3         string       `json:"ja3,omitempty"`
+	JA3Hash     string       `json:"ja3_hash,omitempty"`
+	JA3N        string       `json:"ja3n,omitempty"`
+	JA3NHash    string       `json:"ja3n_hash,omitempty"`
+	JA4         string       `json:"ja4,omitempty"`
+
+	ServerHello *ServerHello `json:"server_hello,omitempty"`
+	JA3S        string       `json:"ja3s,omitempty"`
+	JA3SHash    string       `json:"ja3s_hash,omitempty"`
+	JA4S        string       `json:"ja4s,omitempty"`
+
+	HTTP2      *HTTP2 `json:"http2,omitempty"`
+	Akamai     string `json:"akamai,omitempty"`
+	AkamaiHash string `json:"akamai_hash,omitempty"`
+
+	// Audit is set if ClientHello sent upstream was compared with original one
+	Audit *Audit `json:"audit,omitempty"`
+}
+
+// NewRecord creates record for connection from client to target.
+func NewRecord(client, target string) *Record {
+	return &Record{
+		Time:   time.Now(),
+		Client: client,
+		Target: target,
+	}
+}
+
+// SetClientHello stores ClientHello in record and computes its fingerprints.
+func (r *Record) SetClientHello(ch *ClientHello) {
+	r.ClientHello = ch
+	r.JA3, r.JA3Hash = JA3(ch)
+	r.JA3N, r.JA3NHash = JA3N(ch)
+	r.JA4 = JA4(ch)
+}
+
+// SetServerHello stores ServerHello in record and computes its fingerprints.
+func (r *Record) SetServerHello(sh *ServerHello) {
+	r.ServerHello = sh
+	r.JA3S, r.JA3SHash = JA3S(sh)
+	r.JA4S = JA4S(sh)
+}
+
+// SetHTTP2 stores client HTTP/2 parameters in record and computes their fingerprint.
+func (r *Record) SetHTTP2(h *HTTP2) {
+	r.HTTP2 = h
+	r.Akamai, r.AkamaiHash = Akamai(h)
+}
+
+// RecordWriter writes records as JSON lines. It is safe for concurrent use.
+type RecordWriter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewRecordWriter(w io.Writer) *RecordWriter {
+	return &RecordWriter{enc: json.NewEncoder(w)}
+}
+
+func (rw *RecordWriter) Write(r *Record) error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	return rw.enc.Encode(r)
+}
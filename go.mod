@@ -5,17 +5,19 @@ go 1.21
 toolchain go1.22.5
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/cosiner/flag v0.5.2
 	github.com/elazarl/goproxy v0.0.0-20220529153421-8ea89ba92021
 	github.com/fedosgad/go-http-dialer v0.0.0-20220817082317-794079273155
 	github.com/refraction-networking/utls v1.6.7
 	golang.org/x/net v0.23.0
+	golang.org/x/sys v0.18.0
+	software.sslmate.com/src/go-pkcs12 v0.4.0
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.6 // indirect
 	github.com/cloudflare/circl v1.3.7 // indirect
 	github.com/klauspost/compress v1.17.4 // indirect
 	golang.org/x/crypto v0.21.0 // indirect
-	golang.org/x/sys v0.18.0 // indirect
+	golang.org/x/text v0.14.0 // indirect
 )
@@ -0,0 +1,65 @@
+package har
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"strconv"
+	"sync/atomic"
+)
+
+// http2Preface starts HTTP/2 client connection
+const http2Preface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+var connCounter atomic.Uint64
+
+// connInfo describes connection entries come from
+type connInfo struct {
+	scheme   string
+	host     string // target host, used if request has no Host header
+	serverIP string
+	id       string
+}
+
+// Conns wraps client and server plaintext connections and adds exchanges found in them to log.
+// target is tunnel target ("host:port"). Connections MUST NOT be wrapped before,
+// as TLS is detected by connection type.
+func (hw *Writer) Conns(client, server net.Conn, target string) (net.Conn, net.Conn) {
+	info := connInfo{
+		scheme: "http",
+		host:   target,
+		id:     strconv.FormatUint(connCounter.Add(1), 10),
+	}
+	if _, ok := client.(interface{ ConnectionState() tls.ConnectionState }); ok {
+		info.scheme = "https"
+		if host, port, err := net.SplitHostPort(target); err == nil && port == "443" {
+			info.host = host
+		}
+	} else if host, port, err := net.SplitHostPort(target); err == nil && port == "80" {
+		info.host = host
+	}
+	if addr, ok := server.RemoteAddr().(*net.TCPAddr); ok {
+		info.serverIP = addr.IP.String()
+	}
+
+	c2s, s2c := newTapBuffer(), newTapBuffer()
+	go hw.parse(info, c2s, s2c)
+	return &tapConn{Conn: client, buf: c2s}, &tapConn{Conn: server, buf: s2c}
+}
+
+// parse detects protocol and runs parser
+func (hw *Writer) parse(info connInfo, c2s, s2c *tapBuffer) {
+	defer c2s.Discard()
+	defer s2c.Discard()
+
+	c2sReader := bufio.NewReaderSize(c2s, maxHeadSize)
+	start, err := c2sReader.Peek(3)
+	if err != nil {
+		return
+	}
+	if string(start) == http2Preface[:3] {
+		newHTTP2Parser(hw, info).run(c2sReader, s2c)
+		return
+	}
+	newHTTP1Parser(hw, info).run(c2sReader, bufio.NewReaderSize(s2c, maxHeadSize))
+}
@@ -0,0 +1,58 @@
+package har
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testInfo = connInfo{scheme: "https", host: "example.com", serverIP: "192.0.2.1", id: "1"}
+
+// testWriter returns Writer backed by temporary file and function reading entries written so far
+func testWriter(t *testing.T) (*Writer, func() []*Entry) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.har")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = f.Close() })
+	hw, err := NewWriter(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hw, func() []*Entry {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var har struct{ Log Log }
+		if err := json.Unmarshal(data, &har); err != nil {
+			t.Fatalf("invalid HAR: %v\n%s", err, data)
+		}
+		return har.Log.Entries
+	}
+}
+
+// tapped returns closed tap buffer containing data
+func tapped(data string) *tapBuffer {
+	b := newTapBuffer()
+	b.Write([]byte(data))
+	b.Close()
+	return b
+}
+
+func header(headers []NameValue, name string) string {
+	for _, h := range headers {
+		if h.Name == name {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+func bufioReader(b *tapBuffer) *bufio.Reader {
+	return bufio.NewReaderSize(b, maxHeadSize)
+}
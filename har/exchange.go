@@ -0,0 +1,263 @@
+package har
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"github.com/andybalholm/brotli"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// maxBodySize limits stored body size, the rest is counted but dropped
+const maxBodySize = 10 << 20
+
+// body accumulates message body
+type body struct {
+	data []byte
+	size int
+}
+
+func (b *body) Write(p []byte) (int, error) {
+	b.size += len(p)
+	if room := maxBodySize - len(b.data); room > 0 {
+		b.data = append(b.data, p[:min(room, len(p))]...)
+	}
+	return len(p), nil
+}
+
+func (b *body) truncated() bool {
+	return b.size > len(b.data)
+}
+
+// exchange is request/response pair being reconstructed
+type exchange struct {
+	info connInfo
+
+	mu          sync.Mutex
+	request     Request
+	response    Response
+	reqHeader   http.Header
+	respHeader  http.Header
+	reqBody     body
+	respBody    body
+	start       time.Time
+	reqEnd      time.Time
+	respStart   time.Time
+	respEnd     time.Time
+	hasResponse bool
+
+	reqDone chan struct{} // closed when request is read completely (HTTP/1.1 only)
+}
+
+func newExchange(info connInfo, start time.Time) *exchange {
+	return &exchange{info: info, start: start, reqDone: make(chan struct{})}
+}
+
+// entry builds HAR entry from collected data
+func (x *exchange) entry() *Entry {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	e := &Entry{
+		StartedDateTime: x.start,
+		Request:         x.request,
+		Response:        x.response,
+		ServerIPAddress: x.info.serverIP,
+		Connection:      x.info.id,
+	}
+	e.Request.Cookies = requestCookies(x.reqHeader)
+	e.Request.QueryString = queryString(x.request.URL)
+	e.Request.BodySize = x.reqBody.size
+	if x.reqBody.size > 0 {
+		e.Request.PostData = postData(&x.reqBody, x.reqHeader)
+	}
+
+	reqEnd := x.reqEnd
+	if reqEnd.IsZero() {
+		reqEnd = x.start
+	}
+	e.Timings.Send = millis(reqEnd.Sub(x.start))
+	if !x.hasResponse {
+		e.Response = Response{HTTPVersion: x.request.HTTPVersion, HeadersSize: -1, BodySize: -1}
+		e.Comment = "no response"
+	} else {
+		e.Response.Cookies = responseCookies(x.respHeader)
+		e.Response.BodySize = x.respBody.size
+		e.Response.Content = content(&x.respBody, x.respHeader)
+		e.Response.RedirectURL = x.respHeader.Get("Location")
+		e.Timings.Wait = millis(x.respStart.Sub(reqEnd))
+		e.Timings.Receive = millis(x.respEnd.Sub(x.respStart))
+	}
+	e.Time = math.Round((e.Timings.Send+e.Timings.Wait+e.Timings.Receive)*1000) / 1000
+	if e.Request.Cookies == nil {
+		e.Request.Cookies = []Cookie{}
+	}
+	if e.Response.Cookies == nil {
+		e.Response.Cookies = []Cookie{}
+	}
+	if e.Response.Headers == nil {
+		e.Response.Headers = []NameValue{}
+	}
+	return e
+}
+
+// millis converts duration to milliseconds, negative durations (e.g. early response) become 0
+func millis(d time.Duration) float64 {
+	if d < 0 {
+		return 0
+	}
+	return float64(d.Microseconds()) / 1000
+}
+
+func requestCookies(h http.Header) []Cookie {
+	var res []Cookie
+	for _, c := range (&http.Request{Header: h}).Cookies() {
+		res = append(res, Cookie{Name: c.Name, Value: c.Value})
+	}
+	return res
+}
+
+func responseCookies(h http.Header) []Cookie {
+	var res []Cookie
+	for _, c := range (&http.Response{Header: h}).Cookies() {
+		cookie := Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Path:     c.Path,
+			Domain:   c.Domain,
+			HTTPOnly: c.HttpOnly,
+			Secure:   c.Secure,
+		}
+		if !c.Expires.IsZero() {
+			expires := c.Expires
+			cookie.Expires = &expires
+		}
+		res = append(res, cookie)
+	}
+	return res
+}
+
+// queryString parses query keeping parameters order
+func queryString(rawURL string) []NameValue {
+	res := []NameValue{}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return res
+	}
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		if n, err := url.QueryUnescape(name); err == nil {
+			name = n
+		}
+		if v, err := url.QueryUnescape(value); err == nil {
+			value = v
+		}
+		res = append(res, NameValue{Name: name, Value: value})
+	}
+	return res
+}
+
+func postData(b *body, h http.Header) *PostData {
+	text, encoding := encodeText(b.data, h.Get("Content-Type"))
+	pd := &PostData{
+		MimeType: h.Get("Content-Type"),
+		Text:     text,
+		Encoding: encoding,
+	}
+	if b.truncated() {
+		pd.Comment = "truncated"
+	}
+	return pd
+}
+
+// content decodes response body according to Content-Encoding
+func content(b *body, h http.Header) Content {
+	c := Content{
+		Size:     b.size,
+		MimeType: h.Get("Content-Type"),
+	}
+	data := b.data
+	if enc := h.Get("Content-Encoding"); enc != "" && !b.truncated() {
+		decoded, err := decodeBody(data, enc)
+		if err == nil {
+			data = decoded
+			c.Size = len(decoded)
+			c.Compression = len(decoded) - b.size
+		} else {
+			c.Comment = "failed to decode " + enc + ": " + err.Error()
+		}
+	}
+	if b.truncated() {
+		c.Comment = "truncated"
+	}
+	c.Text, c.Encoding = encodeText(data, c.MimeType)
+	return c
+}
+
+func decodeBody(data []byte, contentEncoding string) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(data)
+	// Encodings are listed in order they were applied
+	encodings := strings.Split(contentEncoding, ",")
+	for i := len(encodings) - 1; i >= 0; i-- {
+		var err error
+		switch strings.ToLower(strings.TrimSpace(encodings[i])) {
+		case "gzip", "x-gzip":
+			r, err = gzip.NewReader(r)
+		case "deflate":
+			r, err = zlib.NewReader(r)
+		case "br":
+			r = brotli.NewReader(r)
+		case "identity", "":
+		default:
+			return nil, errUnsupportedEncoding
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(io.LimitReader(r, maxBodySize))
+}
+
+var errUnsupportedEncoding = errors.New("unsupported encoding")
+
+// encodeText returns body as text if it is one, base64 otherwise
+func encodeText(data []byte, contentType string) (string, string) {
+	if len(data) == 0 {
+		return "", ""
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	binary := strings.HasPrefix(mediaType, "image/") ||
+		strings.HasPrefix(mediaType, "audio/") ||
+		strings.HasPrefix(mediaType, "video/") ||
+		mediaType == "application/octet-stream"
+	if !binary && utf8.Valid(data) {
+		return string(data), ""
+	}
+	return base64.StdEncoding.EncodeToString(data), "base64"
+}
+
+// headerList converts ordered header fields to HAR headers and http.Header
+func headerList(fields [][2]string) ([]NameValue, http.Header) {
+	list := make([]NameValue, 0, len(fields))
+	h := make(http.Header)
+	for _, f := range fields {
+		list = append(list, NameValue{Name: f[0], Value: f[1]})
+		if !strings.HasPrefix(f[0], ":") {
+			h.Add(f[0], f[1])
+		}
+	}
+	return list, h
+}
@@ -0,0 +1,151 @@
+// Package har reconstructs HTTP/1.1 and HTTP/2 exchanges from decrypted connection data
+// and exports them in HAR 1.2 format.
+//
+// Connection data is only observed: it is copied to parsers without blocking or altering the connection.
+package har
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// HAR 1.2 structures (see http://www.softwareishard.com/blog/har-12-spec/)
+
+type Log struct {
+	Version string   `json:"version"`
+	Creator Creator  `json:"creator"`
+	Entries []*Entry `json:"entries"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	Time            float64   `json:"time"`
+	Request         Request   `json:"request"`
+	Response        Response  `json:"response"`
+	Cache           struct{}  `json:"cache"`
+	Timings         Timings   `json:"timings"`
+	ServerIPAddress string    `json:"serverIPAddress,omitempty"`
+	Connection      string    `json:"connection,omitempty"`
+	Comment         string    `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int         `json:"bodySize"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+}
+
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"` // not in HAR 1.2, but widely supported
+	Comment  string `json:"comment,omitempty"`
+}
+
+type Content struct {
+	Size        int    `json:"size"`
+	Compression int    `json:"compression,omitempty"`
+	MimeType    string `json:"mimeType"`
+	Text        string `json:"text,omitempty"`
+	Encoding    string `json:"encoding,omitempty"`
+	Comment     string `json:"comment,omitempty"`
+}
+
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+const (
+	creatorName    = "mirror_proxy"
+	creatorVersion = "1.0"
+)
+
+// Writer writes HAR file. File is valid HAR after every added entry. It is safe for concurrent use.
+type Writer struct {
+	mu    sync.Mutex
+	w     io.WriteSeeker
+	tail  int64 // offset of log closing brackets
+	count int
+}
+
+// logTail closes entries array and log object
+const logTail = "\n]}}\n"
+
+// NewWriter writes empty log and returns Writer.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	creator, err := json.Marshal(Creator{Name: creatorName, Version: creatorVersion})
+	if err != nil {
+		return nil, err
+	}
+	head := `{"log":{"version":"1.2","creator":` + string(creator) + `,"entries":[`
+	if _, err := io.WriteString(w, head+logTail); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, tail: int64(len(head))}, nil
+}
+
+// Add appends entry to log.
+func (hw *Writer) Add(e *Entry) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	hw.mu.Lock()
+	defer hw.mu.Unlock()
+
+	sep := "\n"
+	if hw.count > 0 {
+		sep = ",\n"
+	}
+	if _, err := hw.w.Seek(hw.tail, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.WriteString(hw.w, sep+string(data)+logTail); err != nil {
+		return err
+	}
+	hw.tail += int64(len(sep) + len(data))
+	hw.count++
+	return nil
+}
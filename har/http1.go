@@ -0,0 +1,219 @@
+package har
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxHeadSize limits size of HTTP/1.1 message head (start line and headers)
+const maxHeadSize = 64 << 10
+
+var errHeadTooLarge = errors.New("message head too large")
+
+// http1Parser pairs requests with responses in HTTP/1.1 connection
+type http1Parser struct {
+	hw   *Writer
+	info connInfo
+
+	pending chan *exchange // requests waiting for response, in order
+	stop    chan struct{}  // closed when response parser exits
+}
+
+func newHTTP1Parser(hw *Writer, info connInfo) *http1Parser {
+	return &http1Parser{
+		hw:      hw,
+		info:    info,
+		pending: make(chan *exchange, 64),
+		stop:    make(chan struct{}),
+	}
+}
+
+func (p *http1Parser) run(c2s, s2c *bufio.Reader) {
+	go p.responses(s2c)
+	p.requests(c2s)
+	close(p.pending)
+	<-p.stop
+}
+
+func (p *http1Parser) requests(r *bufio.Reader) {
+	for {
+		if _, err := r.Peek(1); err != nil {
+			return
+		}
+		x := newExchange(p.info, time.Now())
+		head, err := peekHead(r)
+		if err != nil {
+			return
+		}
+		req, err := http.ReadRequest(r)
+		if err != nil {
+			return
+		}
+		fields := headFields(head)
+		x.request = Request{
+			Method:      req.Method,
+			URL:         p.requestURL(req),
+			HTTPVersion: req.Proto,
+			HeadersSize: len(head),
+		}
+		x.request.Headers, x.reqHeader = headerList(fields)
+
+		// Response parser gets request before its body is read, as server may respond early
+		// (e.g. with "100 Continue")
+		select {
+		case p.pending <- x:
+		case <-p.stop:
+			return
+		}
+
+		_, err = io.Copy(bodyWriter{x: x, req: true}, req.Body)
+		x.mu.Lock()
+		x.reqEnd = time.Now()
+		x.mu.Unlock()
+		close(x.reqDone)
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (p *http1Parser) requestURL(req *http.Request) string {
+	if req.URL.IsAbs() {
+		return req.URL.String() // Proxy request
+	}
+	host := req.Host
+	if host == "" {
+		host = p.info.host
+	}
+	return fmt.Sprintf("%s://%s%s", p.info.scheme, host, req.URL.RequestURI())
+}
+
+func (p *http1Parser) responses(r *bufio.Reader) {
+	defer close(p.stop)
+	for x := range p.pending {
+		if !p.response(r, x) {
+			// Connection ended or is not HTTP anymore, remaining requests have no response
+			for x := range p.pending {
+				p.add(x)
+			}
+			return
+		}
+	}
+}
+
+// response reads response to x and adds entry. It returns false if parsing can not continue.
+func (p *http1Parser) response(r *bufio.Reader, x *exchange) bool {
+	defer p.add(x)
+	req := &http.Request{Method: x.request.Method}
+	var resp *http.Response
+	var head []byte
+	for {
+		if _, err := r.Peek(1); err != nil {
+			return false
+		}
+		respStart := time.Now()
+		var err error
+		head, err = peekHead(r)
+		if err != nil {
+			return false
+		}
+		resp, err = http.ReadResponse(r, req)
+		if err != nil {
+			return false
+		}
+		if resp.StatusCode >= 200 || resp.StatusCode == http.StatusSwitchingProtocols {
+			x.mu.Lock()
+			x.respStart = respStart
+			x.mu.Unlock()
+			break
+		}
+		// Skip informational responses
+	}
+
+	x.mu.Lock()
+	x.hasResponse = true
+	x.response = Response{
+		Status:      resp.StatusCode,
+		StatusText:  strings.TrimSpace(strings.TrimPrefix(resp.Status, fmt.Sprint(resp.StatusCode))),
+		HTTPVersion: resp.Proto,
+		HeadersSize: len(head),
+	}
+	x.response.Headers, x.respHeader = headerList(headFields(head))
+	x.mu.Unlock()
+
+	_, err := io.Copy(bodyWriter{x: x}, resp.Body)
+	x.mu.Lock()
+	x.respEnd = time.Now()
+	x.mu.Unlock()
+
+	return err == nil && resp.StatusCode != http.StatusSwitchingProtocols && !resp.Close
+}
+
+// add adds entry once request is read completely
+func (p *http1Parser) add(x *exchange) {
+	<-x.reqDone
+	_ = p.hw.Add(x.entry())
+}
+
+// bodyWriter writes to exchange request or response body
+type bodyWriter struct {
+	x   *exchange
+	req bool
+}
+
+func (w bodyWriter) Write(p []byte) (int, error) {
+	w.x.mu.Lock()
+	defer w.x.mu.Unlock()
+	if w.req {
+		return w.x.reqBody.Write(p)
+	}
+	return w.x.respBody.Write(p)
+}
+
+// peekHead returns message head (up to and including empty line) without consuming it
+func peekHead(r *bufio.Reader) ([]byte, error) {
+	for n := 1; ; {
+		buf, err := r.Peek(n)
+		if i := bytes.Index(buf, []byte("\r\n\r\n")); i >= 0 {
+			return buf[:i+4], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if n >= maxHeadSize {
+			return nil, errHeadTooLarge
+		}
+		n = max(n+1, r.Buffered())
+		if n == len(buf) {
+			n++
+		}
+	}
+}
+
+// headFields parses header lines of message head keeping their order and case
+func headFields(head []byte) [][2]string {
+	lines := strings.Split(string(head), "\r\n")
+	var fields [][2]string
+	for _, line := range lines[1:] { // skip start line
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(fields) > 0 {
+			// obsolete line folding
+			fields[len(fields)-1][1] += " " + strings.TrimSpace(line)
+			continue
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		fields = append(fields, [2]string{name, strings.TrimSpace(value)})
+	}
+	return fields
+}
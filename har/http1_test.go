@@ -0,0 +1,86 @@
+package har
+
+import (
+	"testing"
+)
+
+func TestHTTP1Pipelined(t *testing.T) {
+	hw, entries := testWriter(t)
+	c2s := "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"GET /b?x=1 HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"POST /c HTTP/1.1\r\nHost: example.com\r\nContent-Type: text/plain\r\nContent-Length: 5\r\n\r\nhello"
+	s2c := "HTTP/1.1 200 OK\r\nContent-Length: 1\r\n\r\na" +
+		"HTTP/1.1 404 Not Found\r\nContent-Length: 0\r\n\r\n" +
+		"HTTP/1.1 201 Created\r\nContent-Length: 2\r\nConnection: close\r\n\r\nok"
+	hw.parse(testInfo, tapped(c2s), tapped(s2c))
+
+	got := entries()
+	want := []struct {
+		url    string
+		status int
+		body   string
+	}{
+		{"https://example.com/a", 200, "a"},
+		{"https://example.com/b?x=1", 404, ""},
+		{"https://example.com/c", 201, "ok"},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d entries, want %d", len(got), len(want))
+	}
+	for i, w := range want {
+		e := got[i]
+		if e.Request.URL != w.url || e.Response.Status != w.status || e.Response.Content.Text != w.body {
+			t.Errorf("entry %d = %s %d %q, want %s %d %q", i,
+				e.Request.URL, e.Response.Status, e.Response.Content.Text, w.url, w.status, w.body)
+		}
+		if e.Connection != testInfo.id {
+			t.Errorf("entry %d connection = %q", i, e.Connection)
+		}
+	}
+	if pd := got[2].Request.PostData; pd == nil || pd.Text != "hello" {
+		t.Errorf("POST data = %+v", pd)
+	}
+}
+
+func TestHTTP1Continue(t *testing.T) {
+	hw, entries := testWriter(t)
+	c2s := "PUT /upload HTTP/1.1\r\nHost: example.com\r\nExpect: 100-continue\r\nContent-Length: 4\r\n\r\ndata" +
+		"GET /next HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	s2c := "HTTP/1.1 100 Continue\r\n\r\n" +
+		"HTTP/1.1 204 No Content\r\n\r\n" +
+		"HTTP/1.1 200 OK\r\nContent-Length: 4\r\n\r\ndone"
+	hw.parse(testInfo, tapped(c2s), tapped(s2c))
+
+	got := entries()
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if e := got[0]; e.Response.Status != 204 || e.Request.PostData == nil || e.Request.PostData.Text != "data" {
+		t.Errorf("first entry = %d %+v, want 204 with request body", e.Response.Status, e.Request.PostData)
+	}
+	if e := got[1]; e.Request.URL != "https://example.com/next" || e.Response.Content.Text != "done" {
+		t.Errorf("second entry = %s %q", e.Request.URL, e.Response.Content.Text)
+	}
+}
+
+func TestHTTP1NoResponse(t *testing.T) {
+	hw, entries := testWriter(t)
+	c2s := "GET /a HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"GET /b HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"GET /c HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	s2c := "HTTP/1.1 200 OK\r\nContent-Length: 0\r\nConnection: close\r\n\r\n"
+	hw.parse(testInfo, tapped(c2s), tapped(s2c))
+
+	got := entries()
+	if len(got) != 3 {
+		t.Fatalf("got %d entries, want 3", len(got))
+	}
+	if got[0].Response.Status != 200 || got[0].Comment != "" {
+		t.Errorf("first entry = %d %q", got[0].Response.Status, got[0].Comment)
+	}
+	for _, e := range got[1:] {
+		if e.Comment != "no response" {
+			t.Errorf("%s comment = %q, want no response", e.Request.URL, e.Comment)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package har
+
+import (
+	"bufio"
+	"errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxDynamicTableSize is HPACK dynamic table size parser accepts. Peers may agree on any size,
+// parser only needs to follow.
+const maxDynamicTableSize = 1 << 24
+
+// http2Parser collects streams of HTTP/2 connection
+type http2Parser struct {
+	hw   *Writer
+	info connInfo
+
+	mu      sync.Mutex
+	streams map[uint32]*h2stream
+}
+
+// h2stream is exchange in progress
+type h2stream struct {
+	x        *exchange
+	reqDone  bool
+	respDone bool
+}
+
+func newHTTP2Parser(hw *Writer, info connInfo) *http2Parser {
+	return &http2Parser{
+		hw:      hw,
+		info:    info,
+		streams: make(map[uint32]*h2stream),
+	}
+}
+
+func (p *http2Parser) run(c2s *bufio.Reader, s2c io.Reader) {
+	preface, err := c2s.Peek(len(http2Preface))
+	if err != nil || string(preface) != http2Preface {
+		return
+	}
+	_, _ = c2s.Discard(len(http2Preface))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		p.readFrames(s2c, false)
+	}()
+	p.readFrames(c2s, true)
+	wg.Wait()
+
+	// Connection is over, add unfinished streams
+	ids := make([]uint32, 0, len(p.streams))
+	for id := range p.streams {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	for _, id := range ids {
+		_ = p.hw.Add(p.streams[id].x.entry())
+	}
+}
+
+func (p *http2Parser) readFrames(r io.Reader, fromClient bool) {
+	fr := http2.NewFramer(nil, r)
+	fr.SetMaxReadFrameSize(1 << 24)
+	decoder := hpack.NewDecoder(4096, nil)
+	decoder.SetAllowedMaxDynamicTableSize(maxDynamicTableSize)
+	fr.ReadMetaHeaders = decoder
+
+	for {
+		frame, err := fr.ReadFrame()
+		var streamErr http2.StreamError
+		if errors.As(err, &streamErr) {
+			// Only this stream is broken, connection goes on
+			p.finish(streamErr.StreamID, true, true)
+			continue
+		}
+		if err != nil {
+			return
+		}
+		switch f := frame.(type) {
+		case *http2.MetaHeadersFrame:
+			if fromClient {
+				p.requestHeaders(f)
+			} else {
+				p.responseHeaders(f)
+			}
+		case *http2.DataFrame:
+			p.data(f, fromClient)
+		case *http2.RSTStreamFrame:
+			p.finish(f.StreamID, true, true)
+		}
+	}
+}
+
+func (p *http2Parser) requestHeaders(f *http2.MetaHeadersFrame) {
+	p.mu.Lock()
+	s, ok := p.streams[f.StreamID]
+	if !ok {
+		s = &h2stream{x: newExchange(p.info, time.Now())}
+		p.streams[f.StreamID] = s
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		fields := make([][2]string, 0, len(f.Fields))
+		for _, hf := range f.Fields {
+			fields = append(fields, [2]string{hf.Name, hf.Value})
+		}
+		x := s.x
+		x.mu.Lock()
+		x.request = Request{
+			Method:      f.PseudoValue("method"),
+			URL:         p.requestURL(f),
+			HTTPVersion: "HTTP/2.0",
+			HeadersSize: -1,
+		}
+		x.request.Headers, x.reqHeader = headerList(fields)
+		x.mu.Unlock()
+	} // Otherwise these are trailers
+	if f.StreamEnded() {
+		p.finish(f.StreamID, true, false)
+	}
+}
+
+func (p *http2Parser) requestURL(f *http2.MetaHeadersFrame) string {
+	scheme := f.PseudoValue("scheme")
+	if scheme == "" {
+		scheme = p.info.scheme
+	}
+	authority := f.PseudoValue("authority")
+	if authority == "" {
+		for _, hf := range f.RegularFields() {
+			if hf.Name == "host" {
+				authority = hf.Value
+				break
+			}
+		}
+	}
+	if authority == "" {
+		authority = p.info.host
+	}
+	return scheme + "://" + authority + f.PseudoValue("path")
+}
+
+func (p *http2Parser) responseHeaders(f *http2.MetaHeadersFrame) {
+	p.mu.Lock()
+	s, ok := p.streams[f.StreamID]
+	p.mu.Unlock()
+	if !ok {
+		return // Pushed or unknown stream
+	}
+
+	x := s.x
+	x.mu.Lock()
+	if !x.hasResponse {
+		status, err := strconv.Atoi(f.PseudoValue("status"))
+		if err != nil || (status < 200 && status != http.StatusSwitchingProtocols) {
+			x.mu.Unlock()
+			return // Skip informational responses
+		}
+		fields := make([][2]string, 0, len(f.Fields))
+		for _, hf := range f.Fields {
+			fields = append(fields, [2]string{hf.Name, hf.Value})
+		}
+		x.hasResponse = true
+		x.respStart = time.Now()
+		x.response = Response{
+			Status:      status,
+			StatusText:  http.StatusText(status),
+			HTTPVersion: "HTTP/2.0",
+			HeadersSize: -1,
+		}
+		x.response.Headers, x.respHeader = headerList(fields)
+	} // Otherwise these are trailers
+	x.mu.Unlock()
+
+	if f.StreamEnded() {
+		p.finish(f.StreamID, false, true)
+	}
+}
+
+func (p *http2Parser) data(f *http2.DataFrame, fromClient bool) {
+	p.mu.Lock()
+	s, ok := p.streams[f.StreamID]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	x := s.x
+	x.mu.Lock()
+	if fromClient {
+		_, _ = x.reqBody.Write(f.Data())
+	} else {
+		_, _ = x.respBody.Write(f.Data())
+	}
+	x.mu.Unlock()
+
+	if f.StreamEnded() {
+		p.finish(f.StreamID, fromClient, !fromClient)
+	}
+}
+
+// finish marks stream halves as done and adds entry once both are
+func (p *http2Parser) finish(id uint32, req, resp bool) {
+	p.mu.Lock()
+	s, ok := p.streams[id]
+	if !ok {
+		p.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	s.x.mu.Lock()
+	if req && !s.reqDone {
+		s.reqDone = true
+		s.x.reqEnd = now
+	}
+	if resp && !s.respDone {
+		s.respDone = true
+		s.x.respEnd = now
+	}
+	s.x.mu.Unlock()
+	done := s.reqDone && s.respDone
+	if done {
+		delete(p.streams, id)
+	}
+	p.mu.Unlock()
+
+	if done {
+		_ = p.hw.Add(s.x.entry())
+	}
+}
@@ -0,0 +1,184 @@
+package har
+
+import (
+	"bytes"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+	"testing"
+	"time"
+)
+
+// h2Writer encodes frames of one connection direction, sharing HPACK state between streams
+type h2Writer struct {
+	buf bytes.Buffer
+	fr  *http2.Framer
+	hb  bytes.Buffer
+	enc *hpack.Encoder
+}
+
+func newH2Writer() *h2Writer {
+	w := &h2Writer{}
+	w.fr = http2.NewFramer(&w.buf, nil)
+	w.enc = hpack.NewEncoder(&w.hb)
+	return w
+}
+
+// headers writes HEADERS frame and returns size of encoded header block
+func (w *h2Writer) headers(t *testing.T, id uint32, end bool, fields ...string) int {
+	t.Helper()
+	w.hb.Reset()
+	for i := 0; i < len(fields); i += 2 {
+		if err := w.enc.WriteField(hpack.HeaderField{Name: fields[i], Value: fields[i+1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size := w.hb.Len()
+	err := w.fr.WriteHeaders(http2.HeadersFrameParam{
+		StreamID:      id,
+		BlockFragment: w.hb.Bytes(),
+		EndStream:     end,
+		EndHeaders:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return size
+}
+
+func (w *h2Writer) data(t *testing.T, id uint32, data string) {
+	t.Helper()
+	if err := w.fr.WriteData(id, true, []byte(data)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTP2DynamicTable(t *testing.T) {
+	hw, entries := testWriter(t)
+
+	client := newH2Writer()
+	client.buf.WriteString(http2Preface)
+	_ = client.fr.WriteSettings()
+	reqFields := func(path string) []string {
+		return []string{":method", "GET", ":scheme", "https", ":authority", "api.example.com", ":path", path,
+			"user-agent", "test-agent/1.0", "x-session", "0123456789abcdef"}
+	}
+	first := client.headers(t, 1, true, reqFields("/one")...)
+	second := client.headers(t, 3, true, reqFields("/two")...)
+	if second >= first {
+		t.Fatalf("second header block (%d bytes) does not use dynamic table (first is %d bytes)", second, first)
+	}
+
+	server := newH2Writer()
+	_ = server.fr.WriteSettings()
+	respFields := []string{":status", "200", "content-type", "text/plain", "x-server", "backend-42"}
+	server.headers(t, 3, false, respFields...)
+	server.data(t, 3, "two")
+	server.headers(t, 1, false, respFields...)
+	server.data(t, 1, "one")
+
+	p := newHTTP2Parser(hw, testInfo)
+	c2s, s2c := newTapBuffer(), newTapBuffer()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(bufioReader(c2s), s2c)
+	}()
+	c2s.Write(client.buf.Bytes())
+	// Responses to unknown streams are ignored, so let requests be parsed first
+	waitStreams(t, p, 2)
+	s2c.Write(server.buf.Bytes())
+	c2s.Close()
+	s2c.Close()
+	<-done
+
+	got := entries()
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	want := map[string]string{
+		"https://api.example.com/two": "two",
+		"https://api.example.com/one": "one",
+	}
+	for _, e := range got {
+		body, ok := want[e.Request.URL]
+		if !ok {
+			t.Errorf("unexpected entry %s", e.Request.URL)
+			continue
+		}
+		delete(want, e.Request.URL)
+		if e.Response.Status != 200 || e.Response.Content.Text != body {
+			t.Errorf("%s response = %d %q, want 200 %q", e.Request.URL, e.Response.Status, e.Response.Content.Text, body)
+		}
+		if h := header(e.Request.Headers, "x-session"); h != "0123456789abcdef" {
+			t.Errorf("%s x-session = %q", e.Request.URL, h)
+		}
+		if h := header(e.Response.Headers, "x-server"); h != "backend-42" {
+			t.Errorf("%s x-server = %q", e.Request.URL, h)
+		}
+		if e.Request.HTTPVersion != "HTTP/2.0" {
+			t.Errorf("%s version = %q", e.Request.URL, e.Request.HTTPVersion)
+		}
+	}
+}
+
+func waitStreams(t *testing.T, p *http2Parser, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		p.mu.Lock()
+		count := len(p.streams)
+		p.mu.Unlock()
+		if count >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d streams parsed, want %d", count, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestHTTP2StreamError(t *testing.T) {
+	hw, entries := testWriter(t)
+
+	client := newH2Writer()
+	client.buf.WriteString(http2Preface)
+	reqFields := func(path string, extra ...string) []string {
+		return append([]string{":method", "GET", ":scheme", "https", ":authority", "example.com", ":path", path}, extra...)
+	}
+	client.headers(t, 1, true, reqFields("/one")...)
+	client.headers(t, 3, true, reqFields("/bad", "x-bad", "bad\x01value")...)
+	client.headers(t, 5, true, reqFields("/three")...)
+
+	server := newH2Writer()
+	server.headers(t, 1, true, ":status", "200", "x-bad", "bad\x01value")
+	server.headers(t, 3, true, ":status", "200")
+	server.headers(t, 5, false, ":status", "200")
+	server.data(t, 5, "three")
+
+	p := newHTTP2Parser(hw, testInfo)
+	c2s, s2c := newTapBuffer(), newTapBuffer()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		p.run(bufioReader(c2s), s2c)
+	}()
+	c2s.Write(client.buf.Bytes())
+	waitStreams(t, p, 2)
+	s2c.Write(server.buf.Bytes())
+	c2s.Close()
+	s2c.Close()
+	<-done
+
+	got := entries()
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	// Stream with broken response headers is finished without response, the next one is parsed
+	if e := got[0]; e.Request.URL != "https://example.com/one" || e.Comment != "no response" {
+		t.Errorf("first entry = %s %q", e.Request.URL, e.Comment)
+	}
+	if e := got[1]; e.Request.URL != "https://example.com/three" || e.Response.Content.Text != "three" {
+		t.Errorf("second entry = %s %q", e.Request.URL, e.Response.Content.Text)
+	}
+}
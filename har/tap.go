@@ -0,0 +1,108 @@
+package har
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+// maxTapSize limits data buffered for parser. If parser falls this far behind,
+// the rest of connection is not parsed.
+const maxTapSize = 32 << 20
+
+var errTapOverflow = errors.New("parser fell behind connection")
+
+// tapBuffer is a bounded pipe: writes never block, reads block until data is available.
+// It is used to pass connection data to parser without slowing connection down.
+// Once buffered data exceeds maxTapSize, it is dropped along with future data and Read returns errTapOverflow.
+type tapBuffer struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	data     []byte
+	closed   bool
+	discard  bool // reader is gone, drop writes
+	overflow bool
+}
+
+func newTapBuffer() *tapBuffer {
+	b := &tapBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *tapBuffer) Write(p []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed || b.discard || b.overflow {
+		return
+	}
+	if len(b.data)+len(p) > maxTapSize {
+		// Parser can not resync on the stream with a gap, so stop parsing
+		b.overflow = true
+		b.data = nil
+		b.cond.Broadcast()
+		return
+	}
+	b.data = append(b.data, p...)
+	b.cond.Signal()
+}
+
+// Close makes Read return io.EOF once buffered data is consumed
+func (b *tapBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
+
+// Discard drops buffered and future data. It is called when parser stops reading.
+func (b *tapBuffer) Discard() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.discard = true
+	b.data = nil
+}
+
+func (b *tapBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 && !b.closed && !b.overflow {
+		b.cond.Wait()
+	}
+	if b.overflow {
+		return 0, errTapOverflow
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	if len(b.data) == 0 {
+		b.data = nil // Let underlying array be collected
+	}
+	return n, nil
+}
+
+// tapConn copies data read from connection to buffer
+type tapConn struct {
+	net.Conn
+	buf       *tapBuffer
+	closeOnce sync.Once
+}
+
+func (c *tapConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		c.buf.Write(p[:n])
+	}
+	if err != nil {
+		c.buf.Close()
+	}
+	return n, err
+}
+
+func (c *tapConn) Close() error {
+	c.closeOnce.Do(c.buf.Close)
+	return c.Conn.Close()
+}
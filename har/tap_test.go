@@ -0,0 +1,46 @@
+package har
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+)
+
+func TestTapBuffer(t *testing.T) {
+	b := newTapBuffer()
+	b.Write([]byte("hello "))
+	b.Write([]byte("world"))
+	b.Close()
+	b.Write([]byte("ignored"))
+	data, err := io.ReadAll(b)
+	if err != nil || string(data) != "hello world" {
+		t.Errorf("ReadAll = %q, %v", data, err)
+	}
+}
+
+func TestTapBufferOverflow(t *testing.T) {
+	b := newTapBuffer()
+	chunk := bytes.Repeat([]byte{'x'}, 1<<20)
+	for i := 0; i <= maxTapSize/len(chunk); i++ {
+		b.Write(chunk)
+	}
+	if len(b.data) != 0 {
+		t.Errorf("%d bytes buffered after overflow", len(b.data))
+	}
+	b.Write(chunk)
+	if _, err := b.Read(make([]byte, 10)); !errors.Is(err, errTapOverflow) {
+		t.Errorf("Read error = %v, want %v", err, errTapOverflow)
+	}
+}
+
+func TestTapBufferDiscard(t *testing.T) {
+	b := newTapBuffer()
+	b.Write([]byte("data"))
+	b.Discard()
+	b.Write([]byte("more"))
+	b.Close()
+	if n, err := b.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("Read = %d, %v after discard", n, err)
+	}
+}
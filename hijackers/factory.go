@@ -2,6 +2,9 @@ package hijackers
 
 import (
 	"crypto/tls"
+	"crypto/x509"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"github.com/fedosgad/mirror_proxy/profiles"
 	"io"
 )
 
@@ -10,7 +13,12 @@ type HijackerFactory struct {
 	allowInsecure        bool
 	keyLogWriter         io.Writer
 	generateCertFunc     func(ips []string, names []string) (*tls.Certificate, error)
+	mirrorCertFunc       func(upstream *x509.Certificate) (*tls.Certificate, error)
 	clientTLSCredentials *ClientTLSCredentials
+	fingerprintLog       *fingerprint.RecordWriter
+	auditClientHello     bool
+	profileFunc          func(target string) *profiles.Profile
+	sniff                bool
 }
 
 func NewHijackerFactory(
@@ -18,14 +26,24 @@ func NewHijackerFactory(
 	allowInsecure bool,
 	keyLogWriter io.Writer,
 	generateCertFunc func(ips []string, names []string) (*tls.Certificate, error),
+	mirrorCertFunc func(upstream *x509.Certificate) (*tls.Certificate, error),
 	clientTLSCredentials *ClientTLSCredentials,
+	fingerprintLog *fingerprint.RecordWriter,
+	auditClientHello bool,
+	profileFunc func(target string) *profiles.Profile,
+	sniff bool,
 ) *HijackerFactory {
 	return &HijackerFactory{
 		dialer:               dialer,
 		allowInsecure:        allowInsecure,
 		keyLogWriter:         keyLogWriter,
 		generateCertFunc:     generateCertFunc,
+		mirrorCertFunc:       mirrorCertFunc,
 		clientTLSCredentials: clientTLSCredentials,
+		fingerprintLog:       fingerprintLog,
+		auditClientHello:     auditClientHello,
+		profileFunc:          profileFunc,
+		sniff:                sniff,
 	}
 }
 
@@ -34,13 +52,21 @@ func (hf *HijackerFactory) Get(mode string) Hijacker {
 	case ModePassthrough:
 		return NewPassThroughHijacker(hf.dialer)
 	case ModeMITM:
-		return NewUTLSHijacker(
+		hj := NewUTLSHijacker(
 			hf.dialer,
 			hf.allowInsecure,
 			hf.keyLogWriter,
 			hf.generateCertFunc,
+			hf.mirrorCertFunc,
 			hf.clientTLSCredentials,
+			hf.fingerprintLog,
+			hf.auditClientHello,
+			hf.profileFunc,
 		)
+		if hf.sniff {
+			return NewSniffingHijacker(hf.dialer, hj)
+		}
+		return hj
 	default:
 		return nil
 	}
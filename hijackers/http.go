@@ -0,0 +1,75 @@
+package hijackers
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+// httpHijacker forwards plaintext HTTP traffic unaltered and logs requests passing through.
+// Its connections are recorded by callers the same way as decrypted TLS ones.
+type httpHijacker struct {
+	dialer Dialer
+}
+
+func NewHTTPHijacker(dialer Dialer) Hijacker {
+	return &httpHijacker{
+		dialer: dialer,
+	}
+}
+
+func (h *httpHijacker) GetConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	_, err := clientRaw.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.GetTunnelConns(url, clientRaw, ctxLogger)
+}
+
+func (h *httpHijacker) GetTunnelConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	remoteConn, err := h.dialer.Dial("tcp", url.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	pipeR, pipeW := io.Pipe()
+	go logHTTPRequests(pipeR, url, ctxLogger)
+	return &httpLogConn{Conn: clientRaw, tee: io.TeeReader(clientRaw, pipeW), pipeW: pipeW}, remoteConn, nil
+}
+
+// httpLogConn copies data read from client to request parser
+type httpLogConn struct {
+	net.Conn
+	tee   io.Reader
+	pipeW *io.PipeWriter
+}
+
+func (c *httpLogConn) Read(p []byte) (int, error) {
+	return c.tee.Read(p)
+}
+
+func (c *httpLogConn) Close() error {
+	_ = c.pipeW.Close()
+	return c.Conn.Close()
+}
+
+// logHTTPRequests parses client stream and logs requests. It always consumes the whole stream,
+// so that client connection is never blocked by it.
+func logHTTPRequests(r io.Reader, target *url.URL, ctxLogger Logger) {
+	defer io.Copy(io.Discard, r)
+
+	br := bufio.NewReader(r)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if err != io.EOF {
+				ctxLogger.Logf("HTTP parsing stopped: %v", err)
+			}
+			return
+		}
+		ctxLogger.Logf("HTTP request to %s: %s %s (Host: %s)", target.Host, req.Method, req.RequestURI, req.Host)
+		_, _ = io.Copy(io.Discard, req.Body)
+		_ = req.Body.Close()
+	}
+}
@@ -0,0 +1,69 @@
+package hijackers
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"sync"
+)
+
+// http2FingerprintLimit is the amount of client data inspected for HTTP/2 fingerprint
+const http2FingerprintLimit = 64 << 10
+
+// http2FingerprintConn passively collects HTTP/2 connection start sent by client.
+// *tls.Conn is embedded so that connection is still recognized as TLS one.
+type http2FingerprintConn struct {
+	*tls.Conn
+
+	mu       sync.Mutex
+	data     []byte
+	finished bool
+	done     func(*fingerprint.HTTP2, error)
+}
+
+// newHTTP2FingerprintConn wraps conn. done is called once, when fingerprint is extracted
+// or it becomes clear it will not be.
+func newHTTP2FingerprintConn(conn *tls.Conn, done func(*fingerprint.HTTP2, error)) *http2FingerprintConn {
+	return &http2FingerprintConn{Conn: conn, done: done}
+}
+
+func (c *http2FingerprintConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.finished {
+		return n, err
+	}
+	if n > 0 {
+		c.data = append(c.data, p[:n]...)
+		h2, parseErr := fingerprint.ParseHTTP2(c.data)
+		switch {
+		case !errors.Is(parseErr, fingerprint.ErrHTTP2Incomplete):
+			c.finish(h2, parseErr)
+		case len(c.data) > http2FingerprintLimit:
+			c.finish(nil, fmt.Errorf("no request headers in first %d bytes", http2FingerprintLimit))
+		}
+	}
+	if err != nil && !c.finished {
+		c.finish(nil, fingerprint.ErrHTTP2Incomplete)
+	}
+	return n, err
+}
+
+func (c *http2FingerprintConn) Close() error {
+	c.mu.Lock()
+	if !c.finished {
+		c.finish(nil, fingerprint.ErrHTTP2Incomplete)
+	}
+	c.mu.Unlock()
+	return c.Conn.Close()
+}
+
+// finish MUST be called with c.mu held
+func (c *http2FingerprintConn) finish(h2 *fingerprint.HTTP2, err error) {
+	c.finished = true
+	c.data = nil
+	c.done(h2, err)
+}
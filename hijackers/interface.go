@@ -17,6 +17,9 @@ type Hijacker interface {
 	// Returned streams are meant to be connected to each other.
 	// Implementation MUST answer to client "HTTP/1.1 200 OK\r\n\r\n"
 	GetConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (client, server net.Conn, err error)
+	// GetTunnelConns is GetConns for already established tunnel (i.e. client's tunnel request
+	// is already answered, e.g. by SOCKS server). It MUST NOT write anything to client on its own.
+	GetTunnelConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (client, server net.Conn, err error)
 }
 
 type Logger interface {
@@ -15,11 +15,19 @@ func NewPassThroughHijacker(dialer Dialer) Hijacker {
 	}
 }
 
-func (h *passThroughHijacker) GetConns(url *url.URL, clientRaw net.Conn, _ Logger) (net.Conn, net.Conn, error) {
-	remoteConn, err := h.dialer.Dial("tcp", url.Host)
+func (h *passThroughHijacker) GetConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	_, remoteConn, err := h.GetTunnelConns(url, clientRaw, ctxLogger)
 	if err != nil {
 		return nil, nil, err
 	}
 	_, err = clientRaw.Write([]byte("HTTP/1.0 200 OK\r\n\r\n"))
 	return clientRaw, remoteConn, err
 }
+
+func (h *passThroughHijacker) GetTunnelConns(url *url.URL, clientRaw net.Conn, _ Logger) (net.Conn, net.Conn, error) {
+	remoteConn, err := h.dialer.Dial("tcp", url.Host)
+	if err != nil {
+		return nil, nil, err
+	}
+	return clientRaw, remoteConn, nil
+}
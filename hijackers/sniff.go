@@ -0,0 +1,122 @@
+package hijackers
+
+import (
+	"bytes"
+	"github.com/fedosgad/mirror_proxy/utils"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Tunneled protocols
+const (
+	ProtocolTLS     = "tls"
+	ProtocolHTTP    = "http"
+	ProtocolUnknown = "unknown"
+)
+
+// sniffTimeout limits waiting for the first client bytes.
+// Protocols where server speaks first are treated as unknown after it.
+const sniffTimeout = 2 * time.Second
+
+var httpMethods = [][]byte{
+	[]byte("GET "),
+	[]byte("POST "),
+	[]byte("HEAD "),
+	[]byte("PUT "),
+	[]byte("DELETE "),
+	[]byte("OPTIONS "),
+	[]byte("PATCH "),
+	[]byte("TRACE "),
+	[]byte("CONNECT "),
+	[]byte("PRI * HTTP/2.0"),
+}
+
+// sniffingHijacker looks at the first bytes sent through tunnel and passes it
+// to the hijacker suitable for detected protocol.
+type sniffingHijacker struct {
+	tls         Hijacker
+	http        Hijacker
+	passthrough Hijacker
+}
+
+func NewSniffingHijacker(dialer Dialer, tlsHijacker Hijacker) Hijacker {
+	return &sniffingHijacker{
+		tls:         tlsHijacker,
+		http:        NewHTTPHijacker(dialer),
+		passthrough: NewPassThroughHijacker(dialer),
+	}
+}
+
+func (h *sniffingHijacker) GetConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	_, err := clientRaw.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.GetTunnelConns(url, clientRaw, ctxLogger)
+}
+
+func (h *sniffingHijacker) GetTunnelConns(url *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	pc := utils.NewPeekConn(clientRaw)
+	protocol, err := sniff(pc)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctxLogger.Logf("Detected protocol: %s", protocol)
+
+	switch protocol {
+	case ProtocolTLS:
+		return h.tls.GetTunnelConns(url, pc, ctxLogger)
+	case ProtocolHTTP:
+		return h.http.GetTunnelConns(url, pc, ctxLogger)
+	default:
+		return h.passthrough.GetTunnelConns(url, pc, ctxLogger)
+	}
+}
+
+// sniff detects protocol by the first bytes sent by client. It waits for more data while
+// they may still turn out to be TLS record header or HTTP request line prefix.
+func sniff(pc *utils.PeekConn) (string, error) {
+	_ = pc.SetReadDeadline(time.Now().Add(sniffTimeout))
+	defer func() {
+		_ = pc.SetReadDeadline(time.Time{})
+	}()
+	data, err := pc.Peek()
+	for err == nil {
+		protocol, ok := detectProtocol(data)
+		if ok {
+			return protocol, nil
+		}
+		var more []byte
+		more, err = pc.PeekN(len(data) + 1)
+		if err == nil {
+			data = more
+		}
+	}
+	if os.IsTimeout(err) || len(data) > 0 && err == io.EOF {
+		return ProtocolUnknown, nil
+	}
+	return "", err
+}
+
+// detectProtocol returns protocol of data, or false if more data is needed to tell it
+func detectProtocol(data []byte) (string, bool) {
+	if len(data) >= 2 && data[0] == 0x16 && data[1] == 0x03 {
+		return ProtocolTLS, true
+	}
+	incomplete := len(data) == 0 || len(data) == 1 && data[0] == 0x16
+	for _, m := range httpMethods {
+		if bytes.HasPrefix(data, m) {
+			return ProtocolHTTP, true
+		}
+		if bytes.HasPrefix(m, data) {
+			incomplete = true
+		}
+	}
+	if incomplete {
+		return "", false
+	}
+	return ProtocolUnknown, true
+}
@@ -1,22 +1,37 @@
 package hijackers
 
 import (
+	"crypto/rsa"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"github.com/fedosgad/mirror_proxy/profiles"
 	"github.com/fedosgad/mirror_proxy/utils"
 	utls "github.com/refraction-networking/utls"
 	"io"
 	"net"
 	"net/url"
+	"reflect"
+	"slices"
+	"strings"
 )
 
+// handshakeCaptureLimit is enough to hold the largest TLS record (with header)
+const handshakeCaptureLimit = 5 + 1<<14
+
 type utlsHijacker struct {
 	dialer               Dialer
 	allowInsecure        bool
 	clientTLSConfig      *tls.Config
 	remoteUTLSConfig     *utls.Config
 	generateCertFunc     func(ips []string, names []string) (*tls.Certificate, error)
+	mirrorCertFunc       func(upstream *x509.Certificate) (*tls.Certificate, error)
 	clientTLSCredentials *ClientTLSCredentials
+	fingerprintLog       *fingerprint.RecordWriter
+	auditClientHello     bool
+	profileFunc          func(target string) *profiles.Profile
 }
 
 func NewUTLSHijacker(
@@ -24,7 +39,11 @@ func NewUTLSHijacker(
 	allowInsecure bool,
 	keyLogWriter io.Writer,
 	generateCertFunc func(ips []string, names []string) (*tls.Certificate, error),
+	mirrorCertFunc func(upstream *x509.Certificate) (*tls.Certificate, error),
 	clientTLSCredentials *ClientTLSCredentials,
+	fingerprintLog *fingerprint.RecordWriter,
+	auditClientHello bool,
+	profileFunc func(target string) *profiles.Profile,
 ) Hijacker {
 	return &utlsHijacker{
 		dialer:        dialer,
@@ -36,12 +55,42 @@ func NewUTLSHijacker(
 			KeyLogWriter: keyLogWriter,
 		},
 		generateCertFunc:     generateCertFunc,
+		mirrorCertFunc:       mirrorCertFunc,
 		clientTLSCredentials: clientTLSCredentials,
+		fingerprintLog:       fingerprintLog,
+		auditClientHello:     auditClientHello,
+		profileFunc:          profileFunc,
 	}
 }
 
 func (h *utlsHijacker) GetConns(target *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
+	_, err := clientRaw.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
+	if err != nil {
+		return nil, nil, err
+	}
+	return h.GetTunnelConns(target, clientRaw, ctxLogger)
+}
+
+// ClientHandshakeError is returned when client rejects forged certificate (e.g. due to pinning)
+// after handshake with server succeeded.
+type ClientHandshakeError struct {
+	Target     string // Host from tunnel request
+	ServerName string
+	Err        error
+}
+
+func (e *ClientHandshakeError) Error() string {
+	return fmt.Sprintf("client handshake for %q failed: %v", e.ServerName, e.Err)
+}
+
+func (e *ClientHandshakeError) Unwrap() error {
+	return e.Err
+}
+
+func (h *utlsHijacker) GetTunnelConns(target *url.URL, clientRaw net.Conn, ctxLogger Logger) (net.Conn, net.Conn, error) {
 	var remoteConn net.Conn
+	var upstreamOK bool
+	var record *fingerprint.Record
 
 	clientConnOrig, clientConnCopy := utils.NewTeeConn(clientRaw)
 
@@ -52,16 +101,73 @@ func (h *utlsHijacker) GetConns(target *url.URL, clientRaw net.Conn, ctxLogger L
 		log:   ctxLogger,
 	}
 	clientConfigTemplate := h.clientTLSConfig.Clone()
-	clientConfigTemplate.GetConfigForClient = h.clientHelloCallback(target, clientConfigTemplate, &remoteConn, f, ctxLogger)
+	clientConfigTemplate.GetConfigForClient = h.clientHelloCallback(
+		target,
+		clientConfigTemplate,
+		&remoteConn,
+		&upstreamOK,
+		&record,
+		f,
+		ctxLogger,
+	)
 	plaintextConn := tls.Server(clientConnOrig, clientConfigTemplate)
-	_, err := clientConnOrig.Write([]byte("HTTP/1.1 200 OK\r\n\r\n"))
-	if err != nil {
-		return nil, nil, err
-	}
 
 	go f.extractALPN()
 
-	return plaintextConn, remoteConn, plaintextConn.Handshake() // Return connections so they can be closed
+	err := plaintextConn.Handshake()
+	if err != nil && upstreamOK && certificateRejected(err) {
+		err = &ClientHandshakeError{
+			Target:     target.Hostname(),
+			ServerName: plaintextConn.ConnectionState().ServerName,
+			Err:        err,
+		}
+	}
+	if record != nil {
+		if err == nil && plaintextConn.ConnectionState().NegotiatedProtocol == "h2" {
+			// Record is completed with HTTP/2 fingerprint once client starts sending requests
+			return newHTTP2FingerprintConn(plaintextConn, func(h2 *fingerprint.HTTP2, err error) {
+				if err != nil {
+					ctxLogger.Warnf("HTTP/2 fingerprinting: %v", err)
+				} else {
+					record.SetHTTP2(h2)
+					ctxLogger.Logf("Akamai: %s", record.Akamai)
+				}
+				h.writeRecord(record, ctxLogger)
+			}), remoteConn, nil
+		}
+		h.writeRecord(record, ctxLogger)
+	}
+	return plaintextConn, remoteConn, err // Return connections so they can be closed
+}
+
+// TLS alerts meaning rejection of certificate
+const (
+	alertBadCertificate     = 42
+	alertCertificateUnknown = 46
+	alertUnknownCA          = 48
+)
+
+// certificateRejected reports whether client handshake error means rejection of forged certificate,
+// that is client sent certificate alert. Closed connections, timeouts and other failures do not count,
+// as they are common for flaky networks and aborted connections.
+func certificateRejected(err error) bool {
+	var opErr *net.OpError
+	if !errors.As(err, &opErr) {
+		return false
+	}
+	// crypto/tls reports alerts with unexported uint8 type
+	v := reflect.ValueOf(opErr.Err)
+	if v.Kind() != reflect.Uint8 {
+		return false
+	}
+	if opErr.Op != "remote error" {
+		return false
+	}
+	switch v.Uint() {
+	case alertBadCertificate, alertCertificateUnknown, alertUnknownCA:
+		return true
+	}
+	return false
 }
 
 // clientHelloCallback performs the following tasks:
@@ -72,11 +178,15 @@ func (h *utlsHijacker) GetConns(target *url.URL, clientRaw net.Conn, ctxLogger L
 //
 // - set correct ALPN for client connection using  server response
 //
-// - generate certificate for client (according to client's SNI)
+// - generate certificate for client (according to client's SNI or mirroring server certificate)
+//
+// - restrict client connection to TLS parameters negotiated with server
 func (h *utlsHijacker) clientHelloCallback(
 	target *url.URL,
 	clientConfigTemplate *tls.Config,
 	remoteConnRes *net.Conn,
+	upstreamOK *bool,
+	recordRes **fingerprint.Record,
 	chf clientHelloFingerprinter,
 	ctxLog Logger,
 ) func(*tls.ClientHelloInfo) (*tls.Config, error) {
@@ -91,6 +201,12 @@ func (h *utlsHijacker) clientHelloCallback(
 		if err != nil {
 			return nil, err
 		}
+		// Capture emitted ClientHello and server handshake messages for fingerprint record
+		var captureConn *utils.CaptureConn
+		if h.fingerprintLog != nil || h.auditClientHello {
+			captureConn = utils.NewCaptureConn(remotePlaintextConn, handshakeCaptureLimit)
+			remotePlaintextConn = captureConn
+		}
 		ctxLog.Logf("Remote conn established")
 		needClose := true
 		defer func() {
@@ -133,6 +249,16 @@ func (h *utlsHijacker) clientHelloCallback(
 		remoteConn := utls.UClient(remotePlaintextConn, remoteConfig, utls.HelloCustom)
 		*remoteConnRes = remoteConn // Pass connection back
 		spec := fpRes.helloSpec
+		if h.profileFunc != nil {
+			if profile := h.profileFunc(target.Host); profile != nil {
+				ctxLog.Logf("Using ClientHello profile %s", profile.Name)
+				spec, err = profile.Spec()
+				if err != nil {
+					return nil, fmt.Errorf("profile %s: %v", profile.Name, err)
+				}
+				setALPN(spec, fpRes.nextProtos)
+			}
+		}
 		if spec == nil {
 			return nil, fmt.Errorf("empty fingerprinted spec")
 		}
@@ -148,6 +274,19 @@ func (h *utlsHijacker) clientHelloCallback(
 		if err != nil {
 			return nil, err
 		}
+		serverHandshake := serverHelloRecord(remoteConn)
+		if captureConn != nil {
+			var clientHandshake []byte
+			serverHandshake, clientHandshake = captureConn.Stop()
+			*recordRes = h.reportFingerprint(
+				info.Conn.RemoteAddr().String(),
+				target.Host,
+				fpRes.raw,
+				clientHandshake,
+				serverHandshake,
+				ctxLog,
+			)
+		}
 
 		clientConfig := clientConfigTemplate.Clone()
 		clientConfig.GetConfigForClient = nil
@@ -161,17 +300,140 @@ func (h *utlsHijacker) clientHelloCallback(
 
 		ctxLog.Logf("Certificate generation")
 
-		cert, err := generateCert(info, target.Hostname(), h.generateCertFunc)
+		var cert *tls.Certificate
+		if h.mirrorCertFunc != nil && len(cs.PeerCertificates) > 0 {
+			cert, err = h.mirrorCertFunc(cs.PeerCertificates[0])
+		} else {
+			cert, err = generateCert(info, target.Hostname(), h.generateCertFunc)
+		}
 		if err != nil {
 			return nil, err
 		}
 		clientConfig.Certificates = []tls.Certificate{*cert}
+		mirrorServerParams(clientConfig, info, cs, cert, serverHandshake)
 
 		needClose = false
+		*upstreamOK = true
 		return clientConfig, nil
 	}
 }
 
+// serverHelloRecord returns ServerHello received by conn as TLS record. Unlike captured server handshake
+// it lacks ServerKeyExchange, so TLS 1.2 key exchange group can not be found in it.
+func serverHelloRecord(conn *utls.UConn) []byte {
+	sh := conn.HandshakeState.ServerHello
+	if sh == nil || len(sh.Raw) > 0xffff {
+		return nil
+	}
+	return append([]byte{0x16, 0x03, 0x03, byte(len(sh.Raw) >> 8), byte(len(sh.Raw))}, sh.Raw...)
+}
+
+// mirrorServerParams restricts client config to parameters negotiated with server (version, cipher suite
+// and key exchange group), as long as client offered them and crypto/tls supports them.
+// TLS 1.3 cipher suite can not be chosen with crypto/tls, it is left to client preference.
+func mirrorServerParams(
+	config *tls.Config,
+	info *tls.ClientHelloInfo,
+	cs utls.ConnectionState,
+	cert *tls.Certificate,
+	serverHandshake []byte,
+) {
+	if slices.Contains(info.SupportedVersions, cs.Version) {
+		config.MinVersion = cs.Version
+		config.MaxVersion = cs.Version
+	}
+	if cs.Version < tls.VersionTLS13 &&
+		slices.Contains(info.CipherSuites, cs.CipherSuite) &&
+		cipherSuiteUsable(cs.CipherSuite, cert) {
+		config.CipherSuites = []uint16{cs.CipherSuite}
+	}
+	if group, ok := fingerprint.ServerGroup(serverHandshake); ok {
+		curve := tls.CurveID(group)
+		if slices.Contains(info.SupportedCurves, curve) && slices.Contains(supportedCurves, curve) {
+			config.CurvePreferences = []tls.CurveID{curve}
+		}
+	}
+}
+
+// Key exchange groups implemented by crypto/tls
+var supportedCurves = []tls.CurveID{tls.X25519, tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// cipherSuiteUsable reports whether crypto/tls implements TLS 1.2 cipher suite
+// and it can be used with certificate key
+func cipherSuiteUsable(id uint16, cert *tls.Certificate) bool {
+	_, isRSA := cert.PrivateKey.(*rsa.PrivateKey)
+	for _, suite := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		if suite.ID == id {
+			return strings.Contains(suite.Name, "_ECDSA_") != isRSA
+		}
+	}
+	return false
+}
+
+// setALPN replaces ALPN offer of spec with client's one, as client must be able to speak negotiated protocol.
+// ALPN extension is removed if client sent none.
+func setALPN(spec *utls.ClientHelloSpec, nextProtos []string) {
+	for i, ext := range spec.Extensions {
+		alpn, ok := ext.(*utls.ALPNExtension)
+		if !ok {
+			continue
+		}
+		if len(nextProtos) == 0 {
+			spec.Extensions = append(spec.Extensions[:i], spec.Extensions[i+1:]...)
+			return
+		}
+		alpn.AlpnProtocols = nextProtos
+		return
+	}
+}
+
+// reportFingerprint parses hello messages, audits ClientHello sent upstream (if enabled)
+// and returns fingerprint record
+func (h *utlsHijacker) reportFingerprint(
+	client, target string,
+	clientHello, emittedClientHello, serverHello []byte,
+	ctxLog Logger,
+) *fingerprint.Record {
+	rec := fingerprint.NewRecord(client, target)
+	ch, err := fingerprint.ParseClientHello(clientHello)
+	if err != nil {
+		ctxLog.Warnf("Fingerprinting: %v", err)
+		return nil
+	}
+	rec.SetClientHello(ch)
+	sh, err := fingerprint.ParseServerHello(serverHello)
+	if err != nil {
+		ctxLog.Warnf("Fingerprinting: %v", err)
+	} else {
+		rec.SetServerHello(sh)
+	}
+	ctxLog.Logf("JA3: %s, JA4: %s, JA3S: %s, JA4S: %s", rec.JA3Hash, rec.JA4, rec.JA3SHash, rec.JA4S)
+
+	if h.auditClientHello {
+		emitted, err := fingerprint.ParseClientHello(emittedClientHello)
+		if err != nil {
+			ctxLog.Warnf("ClientHello audit: %v", err)
+		} else {
+			rec.Audit = fingerprint.Compare(ch, emitted)
+			for _, m := range rec.Audit.Mismatches {
+				ctxLog.Warnf("ClientHello mismatch for %s: %s", target, m)
+			}
+		}
+	}
+
+	return rec
+}
+
+// writeRecord writes fingerprint record (if enabled)
+func (h *utlsHijacker) writeRecord(rec *fingerprint.Record, ctxLog Logger) {
+	if h.fingerprintLog == nil {
+		return
+	}
+	if err := h.fingerprintLog.Write(rec); err != nil {
+		ctxLog.Warnf("Error writing fingerprint record: %v", err)
+	}
+}
+
 func generateCert(
 	info *tls.ClientHelloInfo,
 	target string,
@@ -200,6 +462,7 @@ type clientHelloFingerprinter struct {
 
 // fpResult is a container struct for client`s clientHello fingerprinting results
 type fpResult struct {
+	raw        []byte // TLS record with ClientHello
 	helloSpec  *utls.ClientHelloSpec
 	nextProtos []string
 }
@@ -252,7 +515,8 @@ func (f clientHelloFingerprinter) extractALPN() {
 		AllowBluntMimicry: true,
 		AlwaysAddPadding:  false,
 	}
-	clientHelloSpec, err := fp.FingerprintClientHello(append(tlsHeader, clientHelloBody...))
+	raw := append(tlsHeader, clientHelloBody...)
+	clientHelloSpec, err := fp.FingerprintClientHello(raw)
 	if err != nil {
 		f.log.Logf("Client hello fingerprinting error %v", err)
 		f.errCh <- err
@@ -260,6 +524,7 @@ func (f clientHelloFingerprinter) extractALPN() {
 	}
 	f.log.Logf("Sending fpRes")
 	f.fpCh <- &fpResult{
+		raw:        raw,
 		helloSpec:  clientHelloSpec,
 		nextProtos: nextProtos,
 	}
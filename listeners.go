@@ -0,0 +1,394 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"github.com/elazarl/goproxy"
+	"github.com/fedosgad/mirror_proxy/cert_generator"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"github.com/fedosgad/mirror_proxy/har"
+	"github.com/fedosgad/mirror_proxy/hijackers"
+	"github.com/fedosgad/mirror_proxy/pcapng"
+	"github.com/fedosgad/mirror_proxy/profiles"
+	"github.com/fedosgad/mirror_proxy/recorder"
+	"github.com/fedosgad/mirror_proxy/rules"
+	"github.com/fedosgad/mirror_proxy/transparent"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+)
+
+// Listener types
+const (
+	ListenerHTTP        = "http"
+	ListenerSOCKS5      = "socks5"
+	ListenerTransparent = "transparent"
+	ListenerReverse     = "reverse"
+)
+
+// optionNone disables option for listener instead of taking it from command line options
+const optionNone = "none"
+
+// ListenerConfig describes proxy listener. Empty mode, rules, upstream proxy and key log file
+// are taken from command line options. Rules, upstream proxy and key log file set to "none" are not used.
+type ListenerConfig struct {
+	Type    string `json:"type"`
+	Network string `json:"network,omitempty"` // tcp (default) or unix
+	Address string `json:"address"`
+
+	Mode       string `json:"mode,omitempty"`
+	RulesFile  string `json:"rules,omitempty"`
+	ProxyAddr  string `json:"proxy,omitempty"`
+	SSLLogFile string `json:"sslkeylog,omitempty"`
+
+	// TLS to proxy itself (HTTP listener only)
+	TLS     bool   `json:"tls,omitempty"`
+	TLSCert string `json:"tls_cert,omitempty"`
+	TLSKey  string `json:"tls_key,omitempty"`
+
+	// Type-specific options
+	SOCKSUser     string `json:"socks_user,omitempty"`
+	SOCKSPassword string `json:"socks_password,omitempty"`
+	SOCKSUDP      bool   `json:"socks_udp,omitempty"`
+	TProxy        bool   `json:"tproxy,omitempty"`
+	Upstream      string `json:"upstream,omitempty"`
+}
+
+// Config is listeners configuration file.
+type Config struct {
+	Listeners []ListenerConfig `json:"listeners"`
+}
+
+// getListenerConfigs returns listeners from configuration file, if any, or ones set with command line options.
+func getListenerConfigs(opts *Options) ([]ListenerConfig, error) {
+	listeners := opts.listenerConfigs()
+	if opts.ConfigFile != "" {
+		f, err := os.Open(opts.ConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+		var cfg Config
+		if err := dec.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("parsing %s: %v", opts.ConfigFile, err)
+		}
+		if len(cfg.Listeners) == 0 {
+			return nil, fmt.Errorf("no listeners in %s", opts.ConfigFile)
+		}
+		listeners = cfg.Listeners
+		for i := range listeners {
+			listeners[i].setDefaults(opts)
+		}
+	}
+	for i := range listeners {
+		if err := listeners[i].check(); err != nil {
+			return nil, fmt.Errorf("listener %d (%s): %v", i+1, listeners[i].Address, err)
+		}
+	}
+	return listeners, nil
+}
+
+// listenerConfigs returns listeners set with command line options
+func (o *Options) listenerConfigs() []ListenerConfig {
+	res := []ListenerConfig{{
+		Type:    ListenerHTTP,
+		Address: o.ListenAddress,
+		TLS:     o.ListenTLS,
+		TLSCert: o.ListenCert,
+		TLSKey:  o.ListenKey,
+	}}
+	if o.SOCKSAddress != "" {
+		res = append(res, ListenerConfig{
+			Type:          ListenerSOCKS5,
+			Address:       o.SOCKSAddress,
+			SOCKSUser:     o.SOCKSUser,
+			SOCKSPassword: o.SOCKSPassword,
+			SOCKSUDP:      o.SOCKSUDP,
+		})
+	}
+	if o.TransparentAddress != "" {
+		res = append(res, ListenerConfig{Type: ListenerTransparent, Address: o.TransparentAddress, TProxy: o.TProxy})
+	}
+	if o.ReverseAddress != "" {
+		res = append(res, ListenerConfig{Type: ListenerReverse, Address: o.ReverseAddress, Upstream: o.Upstream})
+	}
+	for i := range res {
+		res[i].setDefaults(o)
+	}
+	return res
+}
+
+func (lc *ListenerConfig) setDefaults(opts *Options) {
+	if lc.Network == "" {
+		lc.Network = "tcp"
+	}
+	if lc.Mode == "" {
+		lc.Mode = opts.Mode
+	}
+	lc.RulesFile = withDefault(lc.RulesFile, opts.RulesFile)
+	lc.ProxyAddr = withDefault(lc.ProxyAddr, opts.ProxyAddr)
+	lc.SSLLogFile = withDefault(lc.SSLLogFile, opts.SSLLogFile)
+}
+
+// withDefault returns defaultValue if value is empty and empty string if it is optionNone
+func withDefault(value, defaultValue string) string {
+	switch value {
+	case "":
+		return defaultValue
+	case optionNone:
+		return ""
+	}
+	return value
+}
+
+func (lc *ListenerConfig) check() error {
+	switch lc.Type {
+	case ListenerHTTP, ListenerSOCKS5, ListenerTransparent, ListenerReverse:
+	default:
+		return fmt.Errorf("unknown type %q", lc.Type)
+	}
+	if lc.Address == "" {
+		return fmt.Errorf("no address")
+	}
+	if lc.Network != "tcp" && lc.Network != "unix" {
+		return fmt.Errorf("unknown network %q", lc.Network)
+	}
+	if lc.Type == ListenerTransparent && lc.Network != "tcp" {
+		return fmt.Errorf("transparent listener must be TCP one")
+	}
+	if lc.Type == ListenerTransparent && !transparent.Supported {
+		return fmt.Errorf("transparent listener is only supported on Linux")
+	}
+	if lc.Mode != hijackers.ModeMITM && lc.Mode != hijackers.ModePassthrough {
+		return fmt.Errorf("unknown mode %q", lc.Mode)
+	}
+	if lc.TLS && lc.Type != ListenerHTTP {
+		return fmt.Errorf("TLS is only supported for HTTP listener")
+	}
+	if !lc.TLS && (lc.TLSCert != "" || lc.TLSKey != "") {
+		return fmt.Errorf("TLS certificate is set, but TLS is disabled")
+	}
+	if (lc.TLSCert == "") != (lc.TLSKey == "") {
+		return fmt.Errorf("both TLS certificate and key must be set")
+	}
+	if lc.Type == ListenerReverse {
+		if _, _, err := net.SplitHostPort(lc.Upstream); err != nil {
+			return fmt.Errorf("invalid upstream: %v", err)
+		}
+	}
+	return nil
+}
+
+// proxyEnv holds state shared by all listeners
+type proxyEnv struct {
+	opts                 *Options
+	pw                   *pcapng.Writer
+	fpLog                *fingerprint.RecordWriter
+	rec                  *recorder.Recorder
+	harWriter            *har.Writer
+	learned              *rules.Learned
+	store                *profiles.Store
+	ca                   tls.Certificate
+	certCache            *cert_generator.CertCache
+	mirrorCertFunc       func(upstream *x509.Certificate) (*tls.Certificate, error)
+	clientTLSCredentials *hijackers.ClientTLSCredentials
+
+	keyLogWriters map[string]io.Writer // by path, as listeners may share key log file
+}
+
+// keyLogWriter returns writer of key log file at path (including pcapng file, if it is written)
+func (env *proxyEnv) keyLogWriter(path string) (io.Writer, error) {
+	if w, ok := env.keyLogWriters[path]; ok {
+		return w, nil
+	}
+	klw, err := getSSLLogWriter(path)
+	if err != nil {
+		return nil, err
+	}
+	var w io.Writer = klw
+	if env.pw != nil {
+		w = io.MultiWriter(klw, env.pw.KeyLogWriter())
+	}
+	env.keyLogWriters[path] = w
+	return w, nil
+}
+
+// listen starts listening according to lc and returns function serving connections
+func (env *proxyEnv) listen(lc ListenerConfig, policy *rules.Rules) (func() error, error) {
+	opts := env.opts
+	keyLogWriter, err := env.keyLogWriter(lc.SSLLogFile)
+	if err != nil {
+		return nil, fmt.Errorf("opening key log file: %v", err)
+	}
+	profileFunc, err := getProfileFunc(env.store, opts.Profile, policy)
+	if err != nil {
+		return nil, fmt.Errorf("loading ClientHello profiles: %v", err)
+	}
+	dialer, err := getDialer(opts, lc.ProxyAddr)
+	if err != nil {
+		return nil, fmt.Errorf("getting proxy dialer: %v", err)
+	}
+	if env.pw != nil {
+		dialer = env.pw.Dialer(dialer)
+	}
+
+	hjf := hijackers.NewHijackerFactory(
+		dialer,
+		opts.AllowInsecure,
+		keyLogWriter,
+		env.certCache.GenChildCert,
+		env.mirrorCertFunc,
+		env.clientTLSCredentials,
+		env.fpLog,
+		opts.AuditClientHello,
+		profileFunc,
+		opts.Sniff,
+	)
+	hjs := map[string]hijackers.Hijacker{
+		rules.ActionMITM:        hjf.Get(hijackers.ModeMITM),
+		rules.ActionPassthrough: hjf.Get(hijackers.ModePassthrough),
+	}
+
+	// actionFor selects action for tunnel to host requested by ctx.Req.RemoteAddr
+	actionFor := func(host string, ctx *goproxy.ProxyCtx) string {
+		action := policy.Action(host, lc.Mode)
+		ctx.Logf("Action for %s: %s", host, action)
+		if action == rules.ActionMITM && env.learned != nil &&
+			env.learned.Contains(hostOnly(ctx.Req.RemoteAddr), hostOnly(host)) {
+			ctx.Logf("Passing %s through (learned)", host)
+			action = rules.ActionPassthrough
+		}
+		return action
+	}
+	hijackFunc := func(action string, tunnel bool) func(*http.Request, net.Conn, *goproxy.ProxyCtx) {
+		return getTLSHijackFunc(hjs[action], env.learned, env.rec, env.harWriter, tunnel)
+	}
+	// dialFirst connects passed through tunnel before client is told it is established
+	dialFirst := func(target string) (func(*http.Request, net.Conn, *goproxy.ProxyCtx), error) {
+		remote, err := dialer.Dial("tcp", target)
+		if err != nil {
+			return nil, err
+		}
+		hj := hijackers.NewPassThroughHijacker(dialedConn{conn: remote})
+		return getTLSHijackFunc(hj, env.learned, env.rec, env.harWriter, true), nil
+	}
+
+	// Proxy server also provides logging for other listener types
+	p := goproxy.NewProxyHttpServer()
+	p.Verbose = opts.Verbose
+
+	l, err := netListen(lc)
+	if err != nil {
+		return nil, err
+	}
+	if env.pw != nil {
+		l = env.pw.Listener(l)
+	}
+	if lc.TLS {
+		tlsConfig, err := env.listenerTLSConfig(lc, keyLogWriter)
+		if err != nil {
+			_ = l.Close()
+			return nil, fmt.Errorf("loading TLS certificate: %v", err)
+		}
+		l = tls.NewListener(l, tlsConfig)
+	}
+
+	switch lc.Type {
+	case ListenerSOCKS5:
+		socksServer := newSOCKSServer(lc, opts.Verbose, p, policy, actionFor, hijackFunc, dialFirst)
+		return func() error {
+			return socksServer.Serve(l)
+		}, nil
+	case ListenerTransparent:
+		transparentServer := transparent.NewServer(
+			getTunnelConnectFunc(p, "Transparent", actionFor, hijackFunc, nil),
+			lc.TProxy,
+			opts.Verbose,
+		)
+		return func() error {
+			return transparentServer.Serve(l)
+		}, nil
+	case ListenerReverse:
+		connect := getTunnelConnectFunc(p, "Reverse", actionFor, hijackFunc, nil)
+		return func() error {
+			return serveReverse(l, lc.Upstream, connect)
+		}, nil
+	}
+
+	// Handle all CONNECT requests
+	p.OnRequest(goproxy.ReqHostMatches(regexp.MustCompile("^.*$"))).
+		HandleConnect(goproxy.FuncHttpsHandler(
+			func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
+				action := actionFor(host, ctx)
+				if action == rules.ActionBlock {
+					return goproxy.RejectConnect, host
+				}
+				return &goproxy.ConnectAction{
+					Action: goproxy.ConnectHijack,
+					Hijack: hijackFunc(action, false),
+				}, host
+			}))
+	if env.ca.Leaf != nil {
+		caHandler := newCAHandler(env.ca.Leaf)
+		if env.learned != nil {
+			caHandler.Handle("/learned", env.learned)
+		}
+		// Requests addressed to proxy itself
+		p.NonproxyHandler = caHandler
+		handleCAHost(p, caHandler)
+	}
+	return func() error {
+		return http.Serve(l, p)
+	}, nil
+}
+
+// listenerTLSConfig returns configuration for TLS connections to proxy. Without certificate in lc
+// one is forged for name requested by client (or for listener address if client sent no SNI).
+func (env *proxyEnv) listenerTLSConfig(lc ListenerConfig, keyLogWriter io.Writer) (*tls.Config, error) {
+	cfg := &tls.Config{
+		NextProtos:   []string{"http/1.1"}, // Proxy does not speak HTTP/2
+		KeyLogWriter: keyLogWriter,
+	}
+	if lc.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(lc.TLSCert, lc.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+		return cfg, nil
+	}
+	cfg.GetCertificate = func(info *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if info.ServerName != "" {
+			return env.certCache.GenChildCert(nil, []string{info.ServerName})
+		}
+		host, _, err := net.SplitHostPort(info.Conn.LocalAddr().String())
+		if err != nil || net.ParseIP(host) == nil {
+			// E.g. Unix socket
+			return env.certCache.GenChildCert(nil, []string{"localhost"})
+		}
+		return env.certCache.GenChildCert([]string{host}, nil)
+	}
+	return cfg, nil
+}
+
+// netListen creates listener for lc
+func netListen(lc ListenerConfig) (net.Listener, error) {
+	switch {
+	case lc.Type == ListenerTransparent:
+		return transparent.Listen(lc.Address, lc.TProxy)
+	case lc.Network == "unix":
+		// Remove socket left by previous run
+		if fi, err := os.Stat(lc.Address); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(lc.Address)
+		}
+		return net.Listen("unix", lc.Address)
+	default:
+		return net.Listen("tcp", lc.Address)
+	}
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
-	"github.com/elazarl/goproxy"
 	http_dialer "github.com/fedosgad/go-http-dialer"
 	"github.com/fedosgad/mirror_proxy/cert_generator"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"github.com/fedosgad/mirror_proxy/har"
 	"github.com/fedosgad/mirror_proxy/hijackers"
+	"github.com/fedosgad/mirror_proxy/pcapng"
+	"github.com/fedosgad/mirror_proxy/profiles"
+	"github.com/fedosgad/mirror_proxy/recorder"
+	"github.com/fedosgad/mirror_proxy/rules"
 	utls "github.com/refraction-networking/utls"
 	"golang.org/x/net/proxy"
 	"io"
@@ -14,29 +21,118 @@ import (
 	"net/http"
 	"net/url"
 	"os"
-	"regexp"
+	"strings"
+	"time"
 )
 
 func main() {
 	opts := getOptions()
+	if opts.CA.Enable {
+		if err := generateCA(&opts.CA); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	listeners, err := getListenerConfigs(opts)
+	if err != nil {
+		log.Fatalf("Error reading listeners configuration: %v", err)
+	}
 
-	klw, err := getSSLLogWriter(opts)
+	pcapFile, err := getPcapngWriter(opts)
 	if err != nil {
-		log.Fatalf("Error opening key log file: %v", err)
+		log.Fatalf("Error opening pcapng file: %v", err)
+	}
+	defer pcapFile.Close()
+	var pw *pcapng.Writer
+	if opts.PcapngFile != "" {
+		pw, err = pcapng.NewWriter(pcapFile)
+		if err != nil {
+			log.Fatalf("Error writing pcapng file: %v", err)
+		}
+	}
+
+	fpLogFile, err := getFingerprintLogWriter(opts)
+	if err != nil {
+		log.Fatalf("Error opening fingerprint log file: %v", err)
+	}
+	defer fpLogFile.Close()
+	var fpLog *fingerprint.RecordWriter
+	if opts.FingerprintLogFile != "" {
+		fpLog = fingerprint.NewRecordWriter(fpLogFile)
+	}
+
+	rec, err := getRecorder(opts)
+	if err != nil {
+		log.Fatalf("Error opening recorder: %v", err)
+	}
+	if rec != nil {
+		defer rec.Close()
+	}
+
+	var harWriter *har.Writer
+	if opts.HARFile != "" {
+		harFile, err := os.Create(opts.HARFile)
+		if err != nil {
+			log.Fatalf("Error opening HAR file: %v", err)
+		}
+		defer harFile.Close()
+		harWriter, err = har.NewWriter(harFile)
+		if err != nil {
+			log.Fatalf("Error writing HAR file: %v", err)
+		}
+	}
+
+	// CA is only needed if some listener intercepts connections or forges its own certificate
+	policies := make([]*rules.Rules, len(listeners))
+	needCA := false
+	for i, lc := range listeners {
+		policies[i], err = rules.Load(lc.RulesFile)
+		if err != nil {
+			log.Fatalf("Error loading rules: %v", err)
+		}
+		needCA = needCA || lc.Mode == hijackers.ModeMITM || policies[i].Uses(rules.ActionMITM)
+		// Certificate for TLS to proxy itself is forged if not given
+		needCA = needCA || lc.TLS && lc.TLSCert == ""
+	}
+	var learned *rules.Learned
+	if opts.LearnBypass {
+		learned, err = rules.LoadLearned(opts.LearnedFile, opts.LearnThreshold, opts.LearnWindow, opts.LearnedTTL)
+		if err != nil {
+			log.Fatalf("Error loading learned bypass list: %v", err)
+		}
+	}
+
+	store, err := profiles.LoadStore(opts.ProfileDir)
+	if err != nil {
+		log.Fatalf("Error loading ClientHello profiles: %v", err)
+	}
+	if names := store.Names(); len(names) > 0 {
+		log.Printf("Loaded ClientHello profiles: %s", strings.Join(names, ", "))
 	}
-	defer klw.Close()
 
 	var cg *cert_generator.CertificateGenerator
-	if opts.Mode == hijackers.ModeMITM {
-		cg, err = cert_generator.NewCertGeneratorFromFiles(opts.CertFile, opts.KeyFile)
+	var ca tls.Certificate
+	if needCA {
+		ca, err = getCA(opts)
+		if err != nil {
+			log.Fatalf("Error getting CA: %v", err)
+		}
+		leafKeys := cert_generator.NewKeyPool(opts.LeafKeyPool, opts.LeafKey)
+		defer leafKeys.Close()
+		cg, err = cert_generator.NewCertGenerator(ca, leafKeys)
 		if err != nil {
 			log.Fatal(err)
 		}
+		err = setIntermediate(opts, cg)
+		if err != nil {
+			log.Fatalf("Error setting intermediate CA: %v", err)
+		}
 	}
 
-	dialer, err := getDialer(opts)
-	if err != nil {
-		log.Fatalf("Error getting proxy dialer: %v", err)
+	certCache := cert_generator.NewCertCache(cg, opts.CertCacheSize, opts.CertCacheTTL)
+	if opts.Verbose {
+		go logCacheStats(certCache)
 	}
 
 	clientTLSCredentials, err := getClientTLSCredentials(opts)
@@ -44,33 +140,53 @@ func main() {
 		log.Fatal(err)
 	}
 
-	hjf := hijackers.NewHijackerFactory(
-		dialer,
-		opts.AllowInsecure,
-		klw,
-		cg.GenChildCert,
-		clientTLSCredentials,
-	)
-	hj := hjf.Get(opts.Mode)
-
-	p := goproxy.NewProxyHttpServer()
-	// Handle all CONNECT requests
-	p.OnRequest(goproxy.ReqHostMatches(regexp.MustCompile("^.*$"))).
-		HandleConnect(goproxy.FuncHttpsHandler(
-			func(host string, ctx *goproxy.ProxyCtx) (*goproxy.ConnectAction, string) {
-				return &goproxy.ConnectAction{
-					Action: goproxy.ConnectHijack,
-					Hijack: getTLSHijackFunc(hj),
-				}, host
-			}))
-	p.Verbose = opts.Verbose
+	var mirrorCertFunc func(upstream *x509.Certificate) (*tls.Certificate, error)
+	if opts.MirrorCert {
+		mirrorCertFunc = certCache.GenMirroredCert
+	}
+
+	env := &proxyEnv{
+		opts:                 opts,
+		pw:                   pw,
+		fpLog:                fpLog,
+		rec:                  rec,
+		harWriter:            harWriter,
+		learned:              learned,
+		store:                store,
+		ca:                   ca,
+		certCache:            certCache,
+		mirrorCertFunc:       mirrorCertFunc,
+		clientTLSCredentials: clientTLSCredentials,
+		keyLogWriters:        make(map[string]io.Writer),
+	}
 
 	if opts.PprofAddress != "" {
 		go func() {
 			log.Println(http.ListenAndServe(opts.PprofAddress, nil))
 		}()
 	}
-	log.Fatal(http.ListenAndServe(opts.ListenAddress, p))
+
+	serveFuncs := make([]func() error, 0, len(listeners))
+	for i, lc := range listeners {
+		serve, err := env.listen(lc, policies[i])
+		if err != nil {
+			log.Fatalf("Error starting %s listener on %s: %v", lc.Type, lc.Address, err)
+		}
+		serveFuncs = append(serveFuncs, serve)
+	}
+	for _, serve := range serveFuncs[1:] {
+		go func(serve func() error) {
+			log.Fatal(serve())
+		}(serve)
+	}
+	log.Fatal(serveFuncs[0]())
+}
+
+func logCacheStats(cache *cert_generator.CertCache) {
+	for range time.Tick(time.Minute) {
+		hits, misses := cache.Stats()
+		log.Printf("Certificate cache: %d hits, %d misses", hits, misses)
+	}
 }
 
 type writeNopCloser struct {
@@ -81,25 +197,83 @@ func (c writeNopCloser) Close() error {
 	return nil
 }
 
-func getSSLLogWriter(opts *Options) (klw io.WriteCloser, err error) {
+func getSSLLogWriter(path string) (klw io.WriteCloser, err error) {
 	klw = writeNopCloser{Writer: io.Discard}
 
-	if opts.SSLLogFile != "" {
-		klw, err = os.OpenFile(opts.SSLLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if path != "" {
+		klw, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	}
 	return klw, err
 }
 
-func getDialer(opts *Options) (proxy.Dialer, error) {
+func getFingerprintLogWriter(opts *Options) (w io.WriteCloser, err error) {
+	w = writeNopCloser{Writer: io.Discard}
+
+	if opts.FingerprintLogFile != "" {
+		w, err = os.OpenFile(opts.FingerprintLogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	}
+	return w, err
+}
+
+func getRecorder(opts *Options) (*recorder.Recorder, error) {
+	switch {
+	case opts.RecordDir != "":
+		return recorder.NewDirRecorder(opts.RecordDir)
+	case opts.RecordArchive != "":
+		return recorder.NewArchiveRecorder(opts.RecordArchive)
+	default:
+		return nil, nil
+	}
+}
+
+// getProfileFunc returns function selecting ClientHello profile for target:
+// profile of matching rule, if any, or global one. nil is returned if no profiles are used.
+func getProfileFunc(store *profiles.Store, profile string, policy *rules.Rules) (func(target string) *profiles.Profile, error) {
+	var global *profiles.Profile
+	var err error
+	if profile != "" {
+		global, err = store.Get(profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	byName := make(map[string]*profiles.Profile)
+	for _, name := range policy.Profiles() {
+		byName[name], err = store.Get(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if global == nil && len(byName) == 0 {
+		return nil, nil
+	}
+	return func(target string) *profiles.Profile {
+		if r, ok := policy.Match(target); ok && r.Profile != "" {
+			return byName[r.Profile]
+		}
+		return global
+	}, nil
+}
+
+func getPcapngWriter(opts *Options) (w io.WriteCloser, err error) {
+	w = writeNopCloser{Writer: io.Discard}
+
+	if opts.PcapngFile != "" {
+		w, err = os.Create(opts.PcapngFile)
+	}
+	return w, err
+}
+
+func getDialer(opts *Options, proxyAddr string) (proxy.Dialer, error) {
 	// Timeout SHOULD be set. Otherwise, dialing will never succeed if the first address
 	// returned by resolver is not responding (connection will just hang forever).
 	d := &net.Dialer{
 		Timeout: opts.DialTimeout,
 	}
-	if opts.ProxyAddr == "" {
+	if proxyAddr == "" {
 		return d, nil
 	}
-	proxyURL, err := url.Parse(opts.ProxyAddr)
+	proxyURL, err := url.Parse(proxyAddr)
 	if err != nil {
 		return nil, err
 	}
@@ -2,16 +2,43 @@ package main
 
 import (
 	"github.com/cosiner/flag"
+	"github.com/fedosgad/mirror_proxy/cert_generator"
 	"log"
+	"net"
 	"time"
 )
 
 type Options struct {
 	Verbose       bool   `names:"--verbose, -v" usage:"Turn on verbose logging" default:"false"`
 	ListenAddress string `names:"--listen, -l" usage:"Address for proxy to listen on" default:":8080"`
+	ListenTLS     bool   `names:"--listen-tls, -lt" usage:"Accept TLS connections to proxy (for https:// proxy URLs)" default:"false"`
+	ListenCert    string `names:"--listen-cert, -ltc" usage:"Path to proxy TLS certificate (forged with CA for requested name if empty)" default:""`
+	ListenKey     string `names:"--listen-key, -ltk" usage:"Path to proxy TLS key" default:""`
 	PprofAddress  string `names:"--pprof" usage:"Enable profiling server on http://{pprof}/debug/pprof/" default:""`
+	ConfigFile    string `names:"--config, -cf" usage:"Path to JSON file describing listeners (replaces listener options)" default:""`
 
-	Mode string `names:"--mode, -m" usage:"Operation mode (available: mitm, passthrough)" default:"mitm"`
+	SOCKSAddress  string `names:"--socks, -sl" usage:"Address for SOCKS5 proxy to listen on (disabled if empty)" default:""`
+	SOCKSUser     string `names:"--socks-user, -su" usage:"SOCKS5 username (authentication is disabled if empty)" default:""`
+	SOCKSPassword string `names:"--socks-password, -spw" usage:"SOCKS5 password" default:""`
+	SOCKSUDP      bool   `names:"--socks-udp, -sud" usage:"Allow SOCKS5 UDP ASSOCIATE (datagrams are passed through directly)" default:"false"`
+
+	TransparentAddress string `names:"--transparent, -tl" usage:"Address for transparent proxy to listen on (Linux, iptables REDIRECT or TPROXY; disabled if empty)" default:""`
+	TProxy             bool   `names:"--tproxy, -tp" usage:"Transparent listener receives TPROXY connections instead of REDIRECT ones" default:"false"`
+
+	ReverseAddress string `names:"--reverse, -rl" usage:"Address for reverse proxy to listen on (connections are forwarded to upstream; disabled if empty)" default:""`
+	Upstream       string `names:"--upstream, -u" usage:"Reverse proxy upstream (host:port)" default:""`
+
+	Mode        string `names:"--mode, -m" usage:"Operation mode (available: mitm, passthrough)" default:"mitm"`
+	Sniff       bool   `names:"--sniff, -sn" usage:"Detect tunneled protocol in mitm mode, pass non-TLS traffic through" default:"false"`
+	RulesFile   string `names:"--rules, -r" usage:"Path to per-host rules file (mitm, passthrough or block)" default:""`
+	LearnBypass bool   `names:"--learn-bypass, -lb" usage:"Pass hosts through for clients which reject forged certificates" default:"false"`
+	LearnedFile string `names:"--learned-file, -lbf" usage:"Path to file to persist learned bypass list in" default:""`
+
+	LearnThreshold int           `names:"--learn-threshold, -lbn" usage:"Number of certificate rejections within learn window after which host is passed through" default:"3"`
+	LearnWindow    time.Duration `names:"-"`
+	LearnWindowArg string        `names:"--learn-window, -lbw" usage:"Time window certificate rejections are counted in" default:"10m"`
+	LearnedTTL     time.Duration `names:"-"`
+	LearnedTTLArg  string        `names:"--learned-ttl, -lbt" usage:"Time learned hosts are passed through for (0 keeps them forever)" default:"24h"`
 
 	DialTimeout       time.Duration `names:"-"`
 	DialTimeoutArg    string        `names:"--dial-timeout, -dt" usage:"Remote host dialing timeout" default:"5s"`
@@ -21,10 +48,49 @@ type Options struct {
 	HostWithMutualTLS string        `names:"--mutual-tls-host, -mth" usage:"Host where mutual TLS is enabled"`
 	ClientCertFile    string        `names:"--client-cert, -cc" usage:"Path to file with client certificate"`
 	ClientKeyFile     string        `names:"--client-key, -ck" usage:"Path to file with client key"`
-	CertFile          string        `names:"--certificate, -c" usage:"Path to root CA certificate" default:""`
+	CertFile          string        `names:"--certificate, -c" usage:"Path to root CA certificate (CA is generated if empty)" default:""`
 	KeyFile           string        `names:"--key, -k" usage:"Path to root CA key" default:""`
+	CADir             string        `names:"--ca-dir, -cd" usage:"Directory to save generated CA to and load it from on next runs" default:""`
+	IntermediateCert  string        `names:"--intermediate-cert, -ic" usage:"Path to intermediate CA certificate to sign forged certificates with" default:""`
+	IntermediateKey   string        `names:"--intermediate-key, -ik" usage:"Path to intermediate CA key" default:""`
+	GenIntermediate   bool          `names:"--intermediate, -in" usage:"Generate intermediate CA to sign forged certificates with" default:"false"`
 	SSLLogFile        string        `names:"--sslkeylog, -s" usage:"Path to SSL/TLS secrets log file" default:"ssl.log"`
 	AllowInsecure     bool          `names:"--insecure, -i" usage:"Allow connecting to insecure remote hosts" default:"false"`
+
+	FingerprintLogFile string `names:"--fingerprint-log, -fl" usage:"Path to TLS fingerprints log file (JSON lines, disabled if empty)" default:""`
+	AuditClientHello   bool   `names:"--audit, -a" usage:"Compare ClientHello sent upstream with original one and report differences" default:"false"`
+	Profile            string `names:"--profile, -pf" usage:"ClientHello to present upstream instead of client's one: stored profile name, utls preset name (e.g. chrome-120, ios-14) or path to JSON/hex ClientHello file" default:""`
+	ProfileDir         string `names:"--profile-dir, -pd" usage:"Directory with captured ClientHellos (raw, hex, JSON, pcap, pcapng) to use as profiles by file name" default:""`
+
+	RecordDir     string `names:"--record-dir, -rd" usage:"Directory to record decrypted connections data to (file per flow direction)" default:""`
+	RecordArchive string `names:"--record-archive, -ra" usage:"Path to single file to record decrypted connections data to (index is written to <path>.idx)" default:""`
+	PcapngFile    string `names:"--pcapng, -pc" usage:"Path to pcapng file to write connections and TLS secrets to (opens decrypted in Wireshark)" default:""`
+	HARFile       string `names:"--har, -hr" usage:"Path to HAR file to export decrypted HTTP/1.1 and HTTP/2 exchanges to" default:""`
+
+	LeafKey      cert_generator.KeySpec `names:"-"`
+	LeafKeyType  string                 `names:"--leaf-key, -lk" usage:"Forged certificates key type (available: rsa, ecdsa)" default:"rsa"`
+	LeafKeySize  int                    `names:"--leaf-key-size, -lks" usage:"Forged certificates RSA key size" default:"2048"`
+	LeafKeyCurve string                 `names:"--leaf-key-curve, -lkc" usage:"Forged certificates ECDSA curve (available: P256, P384, P521)" default:"P256"`
+	LeafKeyPool  int                    `names:"--leaf-key-pool, -lkp" usage:"Number of pre-generated forged certificates keys" default:"16"`
+
+	CertCacheSize   int           `names:"--cert-cache, -cch" usage:"Number of cached forged certificates (0 disables cache)" default:"1024"`
+	CertCacheTTL    time.Duration `names:"-"`
+	CertCacheTTLArg string        `names:"--cert-cache-ttl, -cct" usage:"Forged certificates cache TTL" default:"1h"`
+	MirrorCert      bool          `names:"--mirror-cert, -mc" usage:"Copy upstream certificate attributes into forged certificates" default:"false"`
+
+	CA CAOptions `names:"ca" usage:"Generate root CA and export it for device setup"`
+}
+
+type CAOptions struct {
+	Enable       bool
+	CommonName   string `names:"--cn" usage:"CA subject common name" default:"mirror_proxy CA"`
+	Organization string `names:"--org" usage:"CA subject organization" default:"mirror_proxy"`
+	KeyType      string `names:"--key-type, -kt" usage:"CA key type (available: rsa, ecdsa)" default:"rsa"`
+	KeySize      int    `names:"--key-size, -ks" usage:"CA RSA key size" default:"2048"`
+	KeyCurve     string `names:"--key-curve, -kc" usage:"CA ECDSA curve (available: P256, P384, P521)" default:"P256"`
+	Days         int    `names:"--days, -d" usage:"CA certificate lifetime in days" default:"3650"`
+	OutDir       string `names:"--out, -o" usage:"Directory to write CA files to" default:"."`
+	Password     string `names:"--password, -pw" usage:"PKCS#12 archive password" default:""`
 }
 
 func getOptions() *Options {
@@ -35,6 +101,16 @@ func getOptions() *Options {
 	}
 	parseDuration(opts.DialTimeoutArg, &opts.DialTimeout)
 	parseDuration(opts.ProxyTimeoutArg, &opts.ProxyTimeout)
+	parseDuration(opts.CertCacheTTLArg, &opts.CertCacheTTL)
+	parseDuration(opts.LearnWindowArg, &opts.LearnWindow)
+	parseDuration(opts.LearnedTTLArg, &opts.LearnedTTL)
+	opts.LeafKey, err = cert_generator.ParseKeySpec(opts.LeafKeyType, opts.LeafKeySize, opts.LeafKeyCurve)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if opts.CA.Enable {
+		return opts
+	}
 	opts.check()
 	return opts
 }
@@ -47,6 +123,15 @@ func (o Options) check() {
 	}
 
 	failIfEmpty(o.ListenAddress, "Please provide listen address")
+	if o.ReverseAddress != "" {
+		failIfEmpty(o.Upstream, "Please provide reverse proxy upstream")
+		if _, _, err := net.SplitHostPort(o.Upstream); err != nil {
+			log.Fatalf("Invalid reverse proxy upstream: %v", err)
+		}
+	}
+	if o.RecordDir != "" && o.RecordArchive != "" {
+		log.Fatal("Please provide either record directory or record archive")
+	}
 	if o.Mode != "mitm" && o.Mode != "passthrough" {
 		log.Fatal()
 	}
@@ -54,9 +139,15 @@ func (o Options) check() {
 		return
 	}
 	// TLS-related options
-	failIfEmpty(o.CertFile, "Please provide certificate file")
-	failIfEmpty(o.KeyFile, "Please provide key file")
+	if o.CertFile != "" || o.KeyFile != "" {
+		failIfEmpty(o.CertFile, "Please provide certificate file")
+		failIfEmpty(o.KeyFile, "Please provide key file")
+	}
 	failIfEmpty(o.SSLLogFile, "Please provide key log file")
+	if o.IntermediateCert != "" || o.IntermediateKey != "" {
+		failIfEmpty(o.IntermediateCert, "Please provide intermediate certificate file")
+		failIfEmpty(o.IntermediateKey, "Please provide intermediate key file")
+	}
 
 	if o.DialTimeout == 0 {
 		log.Println("Warning: timeout=0, connections may hang!")
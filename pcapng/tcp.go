@@ -0,0 +1,281 @@
+package pcapng
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCP flags
+const (
+	flagFIN = 0x01
+	flagSYN = 0x02
+	flagPSH = 0x08
+	flagACK = 0x10
+)
+
+// maxSegment limits payload of synthesized TCP segment
+const maxSegment = 16384
+
+// endpoint is one side of TCP connection
+type endpoint struct {
+	ip   net.IP
+	port uint16
+	seq  uint32
+}
+
+// stream synthesizes TCP packets of single connection
+type stream struct {
+	pw     *Writer
+	client endpoint
+	server endpoint
+	closed bool // guarded by pw.mu
+}
+
+// newStream writes TCP handshake between client and server addresses
+func (pw *Writer) newStream(client, server net.Addr) *stream {
+	c, s := addrEndpoint(client), addrEndpoint(server)
+	if c.ip.To4() == nil || s.ip.To4() == nil {
+		c.ip, s.ip = c.ip.To16(), s.ip.To16()
+	} else {
+		c.ip, s.ip = c.ip.To4(), s.ip.To4()
+	}
+	// Initial sequence numbers do not matter, make them different for readability
+	c.seq, s.seq = 1000, 2000
+	st := &stream{pw: pw, client: c, server: s}
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	now := time.Now()
+	_ = st.writeSegment(now, &st.client, &st.server, flagSYN, nil)
+	_ = st.writeSegment(now, &st.server, &st.client, flagSYN|flagACK, nil)
+	_ = st.writeSegment(now, &st.client, &st.server, flagACK, nil)
+	return st
+}
+
+func addrEndpoint(addr net.Addr) endpoint {
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP != nil {
+		return endpoint{ip: tcpAddr.IP, port: uint16(tcpAddr.Port)}
+	}
+	return endpoint{ip: net.IPv4zero}
+}
+
+// write writes data sent by client (fromClient) or server as TCP segments
+func (st *stream) write(fromClient bool, data []byte) error {
+	src, dst := &st.server, &st.client
+	if fromClient {
+		src, dst = &st.client, &st.server
+	}
+	st.pw.mu.Lock()
+	defer st.pw.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	now := time.Now()
+	for len(data) > 0 {
+		n := min(len(data), maxSegment)
+		if err := st.writeSegment(now, src, dst, flagPSH|flagACK, data[:n]); err != nil {
+			return err
+		}
+		data = data[n:]
+	}
+	return nil
+}
+
+// close writes connection teardown
+func (st *stream) close() error {
+	st.pw.mu.Lock()
+	defer st.pw.mu.Unlock()
+	if st.closed {
+		return nil
+	}
+	st.closed = true
+	now := time.Now()
+	for _, err := range []error{
+		st.writeSegment(now, &st.client, &st.server, flagFIN|flagACK, nil),
+		st.writeSegment(now, &st.server, &st.client, flagFIN|flagACK, nil),
+		st.writeSegment(now, &st.client, &st.server, flagACK, nil),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSegment MUST be called with pw.mu held
+func (st *stream) writeSegment(t time.Time, src, dst *endpoint, flags byte, payload []byte) error {
+	tcp := make([]byte, 20, 20+len(payload))
+	binary.BigEndian.PutUint16(tcp[0:2], src.port)
+	binary.BigEndian.PutUint16(tcp[2:4], dst.port)
+	binary.BigEndian.PutUint32(tcp[4:8], src.seq)
+	if flags&flagACK != 0 {
+		binary.BigEndian.PutUint32(tcp[8:12], dst.seq)
+	}
+	tcp[12] = 5 << 4 // data offset
+	tcp[13] = flags
+	binary.BigEndian.PutUint16(tcp[14:16], 0xffff) // window
+	tcp = append(tcp, payload...)
+
+	src.seq += uint32(len(payload))
+	if flags&(flagSYN|flagFIN) != 0 {
+		src.seq++
+	}
+
+	var packet []byte
+	if len(src.ip) == net.IPv4len {
+		packet = ipv4Header(src.ip, dst.ip, len(tcp))
+	} else {
+		packet = ipv6Header(src.ip, dst.ip, len(tcp))
+	}
+	binary.BigEndian.PutUint16(tcp[16:18], tcpChecksum(src.ip, dst.ip, tcp))
+	packet = append(packet, tcp...)
+	return st.pw.writePacket(t, packet)
+}
+
+func ipv4Header(src, dst net.IP, payloadLen int) []byte {
+	h := make([]byte, 20)
+	h[0] = 0x45 // version 4, header length 20
+	binary.BigEndian.PutUint16(h[2:4], uint16(20+payloadLen))
+	h[6] = 0x40 // don't fragment
+	h[8] = 64   // TTL
+	h[9] = 6    // TCP
+	copy(h[12:16], src)
+	copy(h[16:20], dst)
+	binary.BigEndian.PutUint16(h[10:12], checksum(0, h))
+	return h
+}
+
+func ipv6Header(src, dst net.IP, payloadLen int) []byte {
+	h := make([]byte, 40)
+	h[0] = 0x60 // version 6
+	binary.BigEndian.PutUint16(h[4:6], uint16(payloadLen))
+	h[6] = 6  // next header: TCP
+	h[7] = 64 // hop limit
+	copy(h[8:24], src)
+	copy(h[24:40], dst)
+	return h
+}
+
+func tcpChecksum(src, dst net.IP, segment []byte) uint16 {
+	var sum uint32
+	pseudo := append(append([]byte{}, src...), dst...)
+	if len(src) == net.IPv4len {
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	}
+	sum = sum16(sum, pseudo)
+	return checksum(sum, segment)
+}
+
+func checksum(initial uint32, data []byte) uint16 {
+	sum := sum16(initial, data)
+	for sum>>16 != 0 {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
+
+func sum16(sum uint32, data []byte) uint32 {
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	return sum
+}
+
+// captureConn writes data passing through connection to stream
+type captureConn struct {
+	net.Conn
+	stream *stream
+	// isClient is true if local side of connection is TCP client (connection was dialed)
+	isClient  bool
+	closeOnce sync.Once
+}
+
+func (c *captureConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		_ = c.stream.write(!c.isClient, p[:n])
+	}
+	return n, err
+}
+
+func (c *captureConn) Write(p []byte) (int, error) {
+	// Data is written to file before sending, so that it does not appear after peer's response
+	_ = c.stream.write(c.isClient, p)
+	return c.Conn.Write(p)
+}
+
+func (c *captureConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.stream.close()
+	})
+	return c.Conn.Close()
+}
+
+// NetConn returns wrapped connection.
+func (c *captureConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+// Conn wraps connection so that data passing through it is written to file.
+// isClient tells whether local side of connection is TCP client.
+func (pw *Writer) Conn(conn net.Conn, isClient bool) net.Conn {
+	client, server := conn.RemoteAddr(), conn.LocalAddr()
+	if isClient {
+		client, server = server, client
+	}
+	return &captureConn{
+		Conn:     conn,
+		stream:   pw.newStream(client, server),
+		isClient: isClient,
+	}
+}
+
+// Listener wraps accepted connections with Conn.
+func (pw *Writer) Listener(l net.Listener) net.Listener {
+	return &listener{Listener: l, pw: pw}
+}
+
+type listener struct {
+	net.Listener
+	pw *Writer
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return l.pw.Conn(conn, false), nil
+}
+
+// Dialer is the interface of golang.org/x/net/proxy.Dialer
+type Dialer interface {
+	Dial(network, addr string) (net.Conn, error)
+}
+
+// Dialer wraps dialed connections with Conn.
+func (pw *Writer) Dialer(d Dialer) Dialer {
+	return &dialer{Dialer: d, pw: pw}
+}
+
+type dialer struct {
+	Dialer
+	pw *Writer
+}
+
+func (d *dialer) Dial(network, addr string) (net.Conn, error) {
+	conn, err := d.Dialer.Dial(network, addr)
+	if err != nil {
+		return nil, err
+	}
+	return d.pw.Conn(conn, true), nil
+}
@@ -0,0 +1,108 @@
+// Package pcapng writes proxied connections as synthesized TCP packets to pcapng file,
+// along with TLS key log embedded as Decryption Secrets Blocks, so that file can be opened
+// decrypted in Wireshark without separate capture and key log.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Block types
+const (
+	blockSHB = 0x0a0d0d0a
+	blockIDB = 0x00000001
+	blockEPB = 0x00000006
+	blockDSB = 0x0000000a
+)
+
+const (
+	byteOrderMagic = 0x1a2b3c4d
+	linkTypeRaw    = 101        // raw IPv4/IPv6 packets
+	secretsTLS     = 0x544c534b // "TLSK", NSS key log format
+)
+
+// Writer writes pcapng file with single interface. It is safe for concurrent use.
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriter writes file headers and returns Writer.
+func NewWriter(w io.Writer) (*Writer, error) {
+	pw := &Writer{w: w}
+
+	shb := make([]byte, 16)
+	binary.LittleEndian.PutUint32(shb[0:4], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[4:6], 1) // major version
+	binary.LittleEndian.PutUint16(shb[6:8], 0) // minor version
+	binary.LittleEndian.PutUint64(shb[8:16], 0xffffffffffffffff)
+	if err := pw.writeBlock(blockSHB, shb); err != nil {
+		return nil, err
+	}
+
+	idb := make([]byte, 8)
+	binary.LittleEndian.PutUint16(idb[0:2], linkTypeRaw)
+	// reserved and snaplen (0 - no limit) are zero
+	if err := pw.writeBlock(blockIDB, idb); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+// WriteSecrets writes TLS key log lines as Decryption Secrets Block.
+func (pw *Writer) WriteSecrets(keyLog []byte) error {
+	body := make([]byte, 8, 8+len(keyLog))
+	binary.LittleEndian.PutUint32(body[0:4], secretsTLS)
+	binary.LittleEndian.PutUint32(body[4:8], uint32(len(keyLog)))
+	body = append(body, keyLog...)
+
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.writeBlock(blockDSB, body)
+}
+
+// KeyLogWriter returns writer to be used as tls.Config.KeyLogWriter.
+func (pw *Writer) KeyLogWriter() io.Writer {
+	return keyLogWriter{pw}
+}
+
+type keyLogWriter struct {
+	pw *Writer
+}
+
+func (k keyLogWriter) Write(p []byte) (int, error) {
+	if err := k.pw.WriteSecrets(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// writePacket writes IP packet as Enhanced Packet Block
+func (pw *Writer) writePacket(t time.Time, packet []byte) error {
+	ts := uint64(t.UnixMicro())
+	body := make([]byte, 20, 20+len(packet))
+	binary.LittleEndian.PutUint32(body[0:4], 0) // interface ID
+	binary.LittleEndian.PutUint32(body[4:8], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(body[8:12], uint32(ts))
+	binary.LittleEndian.PutUint32(body[12:16], uint32(len(packet)))
+	binary.LittleEndian.PutUint32(body[16:20], uint32(len(packet)))
+	body = append(body, packet...)
+	return pw.writeBlock(blockEPB, body)
+}
+
+// writeBlock MUST be called with pw.mu held (or before Writer is shared)
+func (pw *Writer) writeBlock(blockType uint32, body []byte) error {
+	padding := (4 - len(body)%4) % 4
+	length := uint32(12 + len(body) + padding)
+	block := make([]byte, 0, length)
+	block = binary.LittleEndian.AppendUint32(block, blockType)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	block = append(block, body...)
+	block = append(block, make([]byte, padding)...)
+	block = binary.LittleEndian.AppendUint32(block, length)
+	_, err := pw.w.Write(block)
+	return err
+}
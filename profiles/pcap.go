@@ -0,0 +1,357 @@
+package profiles
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Capture file magic numbers
+const (
+	pcapMagicMicro = 0xa1b2c3d4
+	pcapMagicNano  = 0xa1b23c4d
+	pcapngSHB      = 0x0a0d0d0a
+)
+
+// pcapng block types
+const (
+	pcapngIDB = 0x00000001
+	pcapngSPB = 0x00000003
+	pcapngEPB = 0x00000006
+)
+
+// Link types
+const (
+	linkNull     = 0
+	linkEthernet = 1
+	linkRaw      = 101
+	linkLoop     = 108
+	linkSLL      = 113
+	linkIPv4     = 228
+	linkIPv6     = 229
+	linkSLL2     = 276
+)
+
+// maxHandshakeLength limits reassembled ClientHello size so that it fits in a single record
+const maxHandshakeLength = 0xffff - 4
+
+// maxPacketLength limits size of captured packet or block; larger ones are skipped
+const maxPacketLength = 256 << 10
+
+// ExtractClientHellos reads pcap or pcapng capture and returns ClientHellos found in TCP streams,
+// in order of appearance. Each ClientHello is returned as a single TLS record.
+func ExtractClientHellos(r io.Reader) ([][]byte, error) {
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(4)
+	if err != nil {
+		return nil, err
+	}
+	a := newStreamAssembler()
+	switch {
+	case binary.LittleEndian.Uint32(magic) == pcapngSHB:
+		err = readPcapng(br, a.packet)
+	case binary.LittleEndian.Uint32(magic) == pcapMagicMicro, binary.LittleEndian.Uint32(magic) == pcapMagicNano:
+		err = readPcap(br, binary.LittleEndian, a.packet)
+	case binary.BigEndian.Uint32(magic) == pcapMagicMicro, binary.BigEndian.Uint32(magic) == pcapMagicNano:
+		err = readPcap(br, binary.BigEndian, a.packet)
+	default:
+		return nil, fmt.Errorf("unknown capture format")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return a.hellos, nil
+}
+
+func readPcap(r io.Reader, order binary.ByteOrder, handle func(linkType int, data []byte)) error {
+	header := make([]byte, 24)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return err
+	}
+	linkType := int(order.Uint32(header[20:24]) & 0xffff)
+	recHeader := make([]byte, 16)
+	for {
+		_, err := io.ReadFull(r, recHeader)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		capLen := order.Uint32(recHeader[8:12])
+		if capLen > maxPacketLength {
+			if _, err := io.CopyN(io.Discard, r, int64(capLen)); err != nil {
+				return err
+			}
+			continue
+		}
+		data := make([]byte, capLen)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return err
+		}
+		handle(linkType, data)
+	}
+}
+
+func readPcapng(r io.Reader, handle func(linkType int, data []byte)) error {
+	var order binary.ByteOrder = binary.LittleEndian
+	var linkTypes []int
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		blockType := binary.LittleEndian.Uint32(header[0:4])
+		if blockType == pcapngSHB {
+			// Byte order is defined by magic in section header
+			bom := make([]byte, 4)
+			if _, err := io.ReadFull(r, bom); err != nil {
+				return err
+			}
+			if binary.BigEndian.Uint32(bom) == 0x1a2b3c4d {
+				order = binary.BigEndian
+			} else {
+				order = binary.LittleEndian
+			}
+			linkTypes = nil
+			length := order.Uint32(header[4:8])
+			if length < 16 {
+				return fmt.Errorf("invalid section header length %d", length)
+			}
+			if _, err := io.CopyN(io.Discard, r, int64(length-12)); err != nil {
+				return err
+			}
+			continue
+		}
+		blockType = order.Uint32(header[0:4])
+		length := order.Uint32(header[4:8])
+		if length < 12 || length%4 != 0 {
+			return fmt.Errorf("invalid block length %d", length)
+		}
+		if length > maxPacketLength {
+			if _, err := io.CopyN(io.Discard, r, int64(length-8)); err != nil {
+				return err
+			}
+			continue
+		}
+		body := make([]byte, length-8)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return err
+		}
+		body = body[:len(body)-4] // trailing length
+		switch blockType {
+		case pcapngIDB:
+			if len(body) < 2 {
+				return errors.New("short interface description block")
+			}
+			linkTypes = append(linkTypes, int(order.Uint16(body[0:2])))
+		case pcapngEPB:
+			if len(body) < 20 {
+				return errors.New("short enhanced packet block")
+			}
+			iface := int(order.Uint32(body[0:4]))
+			capLen := int(order.Uint32(body[12:16]))
+			if iface >= len(linkTypes) || 20+capLen > len(body) {
+				continue
+			}
+			handle(linkTypes[iface], body[20:20+capLen])
+		case pcapngSPB:
+			if len(body) < 4 || len(linkTypes) == 0 {
+				continue
+			}
+			handle(linkTypes[0], body[4:])
+		}
+	}
+}
+
+// streamKey identifies one direction of TCP connection
+type streamKey struct {
+	src, dst string
+}
+
+type stream struct {
+	nextSeq uint32
+	buf     []byte
+	done    bool
+}
+
+// streamAssembler collects first bytes sent in TCP streams until ClientHello is complete
+type streamAssembler struct {
+	streams map[streamKey]*stream
+	hellos  [][]byte
+}
+
+func newStreamAssembler() *streamAssembler {
+	return &streamAssembler{streams: make(map[streamKey]*stream)}
+}
+
+func (a *streamAssembler) packet(linkType int, data []byte) {
+	ipPacket, ok := stripLinkLayer(linkType, data)
+	if !ok {
+		return
+	}
+	src, dst, segment, ok := parseIP(ipPacket)
+	if !ok || len(segment) < 20 {
+		return
+	}
+	dataOffset := int(segment[12]>>4) * 4
+	if dataOffset < 20 || dataOffset > len(segment) {
+		return
+	}
+	key := streamKey{
+		src: fmt.Sprintf("%x:%d", src, binary.BigEndian.Uint16(segment[0:2])),
+		dst: fmt.Sprintf("%x:%d", dst, binary.BigEndian.Uint16(segment[2:4])),
+	}
+	seq := binary.BigEndian.Uint32(segment[4:8])
+	flags := segment[13]
+	payload := segment[dataOffset:]
+
+	const flagSYN = 0x02
+	if flags&flagSYN != 0 {
+		a.streams[key] = &stream{nextSeq: seq + 1}
+		return
+	}
+	s, ok := a.streams[key]
+	if !ok {
+		// Stream started before capture; accept it only if it starts with handshake record
+		if len(payload) == 0 || payload[0] != 0x16 {
+			return
+		}
+		s = &stream{nextSeq: seq}
+		a.streams[key] = s
+	}
+	if s.done || len(payload) == 0 || seq != s.nextSeq {
+		return // Retransmissions and out-of-order segments are ignored
+	}
+	s.nextSeq += uint32(len(payload))
+	s.buf = append(s.buf, payload...)
+
+	hello, complete, valid := assembleClientHello(s.buf)
+	if !valid {
+		s.done = true
+		s.buf = nil
+		return
+	}
+	if complete {
+		s.done = true
+		s.buf = nil
+		a.hellos = append(a.hellos, hello)
+	}
+}
+
+// assembleClientHello collects ClientHello handshake message from (possibly several) records
+// and returns it as a single record
+func assembleClientHello(buf []byte) (hello []byte, complete bool, valid bool) {
+	var msg []byte
+	var version []byte
+	for len(buf) >= 5 {
+		if buf[0] != 0x16 || buf[1] != 0x03 {
+			return nil, false, false
+		}
+		if version == nil {
+			version = buf[1:3]
+		}
+		recordLen := int(binary.BigEndian.Uint16(buf[3:5]))
+		if len(buf) < 5+recordLen {
+			break
+		}
+		msg = append(msg, buf[5:5+recordLen]...)
+		buf = buf[5+recordLen:]
+	}
+	if len(msg) == 0 && len(buf) >= 1 && buf[0] != 0x16 {
+		return nil, false, false
+	}
+	if len(msg) < 4 {
+		return nil, false, true
+	}
+	if msg[0] != 0x01 {
+		return nil, false, false
+	}
+	msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+	if msgLen > maxHandshakeLength {
+		return nil, false, false
+	}
+	if len(msg) < 4+msgLen {
+		return nil, false, true
+	}
+	msg = msg[:4+msgLen]
+	record := []byte{0x16, version[0], version[1], byte(len(msg) >> 8), byte(len(msg))}
+	return append(record, msg...), true, true
+}
+
+// stripLinkLayer returns IP packet contained in frame
+func stripLinkLayer(linkType int, data []byte) ([]byte, bool) {
+	switch linkType {
+	case linkEthernet:
+		if len(data) < 14 {
+			return nil, false
+		}
+		etherType := binary.BigEndian.Uint16(data[12:14])
+		data = data[14:]
+		for etherType == 0x8100 || etherType == 0x88a8 { // VLAN tags
+			if len(data) < 4 {
+				return nil, false
+			}
+			etherType = binary.BigEndian.Uint16(data[2:4])
+			data = data[4:]
+		}
+		return data, etherType == 0x0800 || etherType == 0x86dd
+	case linkNull, linkLoop:
+		if len(data) < 4 {
+			return nil, false
+		}
+		return data[4:], true
+	case linkSLL:
+		if len(data) < 16 {
+			return nil, false
+		}
+		return data[16:], true
+	case linkSLL2:
+		if len(data) < 20 {
+			return nil, false
+		}
+		return data[20:], true
+	case linkRaw, linkIPv4, linkIPv6:
+		return data, true
+	default:
+		return nil, false
+	}
+}
+
+// parseIP returns addresses and TCP segment of IP packet
+func parseIP(data []byte) (src, dst, segment []byte, ok bool) {
+	if len(data) < 1 {
+		return nil, nil, nil, false
+	}
+	const protoTCP = 6
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return nil, nil, nil, false
+		}
+		headerLen := int(data[0]&0x0f) * 4
+		totalLen := int(binary.BigEndian.Uint16(data[2:4]))
+		fragment := binary.BigEndian.Uint16(data[6:8]) & 0x3fff
+		if data[9] != protoTCP || fragment != 0 || headerLen < 20 || totalLen < headerLen || totalLen > len(data) {
+			return nil, nil, nil, false
+		}
+		return data[12:16], data[16:20], data[headerLen:totalLen], true
+	case 6:
+		if len(data) < 40 {
+			return nil, nil, nil, false
+		}
+		payloadLen := int(binary.BigEndian.Uint16(data[4:6]))
+		if data[6] != protoTCP || 40+payloadLen > len(data) {
+			return nil, nil, nil, false // Extension headers are not supported
+		}
+		return data[8:24], data[24:40], data[40 : 40+payloadLen], true
+	default:
+		return nil, nil, nil, false
+	}
+}
@@ -0,0 +1,161 @@
+// Package profiles provides ClientHello specs to present upstream instead of the one sent by client.
+//
+// Profile is either a utls preset referenced by name (e.g. "chrome-120", "ios-14") or a ClientHello
+// loaded from file: utls JSON spec (as produced by tls.peet.ws) or hex dump of raw ClientHello.
+// Store keeps library of named profiles imported from captured ClientHellos (raw, hex, pcap or pcapng).
+package profiles
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	utls "github.com/refraction-networking/utls"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Profile produces ClientHello specs. New spec is produced for every connection,
+// as utls stores connection state in extensions of applied spec.
+type Profile struct {
+	Name    string
+	newSpec func() (*utls.ClientHelloSpec, error)
+}
+
+// Spec returns new ClientHello spec of the profile.
+func (p *Profile) Spec() (*utls.ClientHelloSpec, error) {
+	return p.newSpec()
+}
+
+// Presets supported by utls.UTLSIdToSpec. PSK variants are omitted as they require session state.
+var presets = map[string]utls.ClientHelloID{
+	"chrome":  utls.HelloChrome_Auto,
+	"firefox": utls.HelloFirefox_Auto,
+	"ios":     utls.HelloIOS_Auto,
+	"edge":    utls.HelloEdge_Auto,
+	"safari":  utls.HelloSafari_16_0,
+	"android": utls.HelloAndroid_11_OkHttp,
+}
+
+func init() {
+	for _, id := range []utls.ClientHelloID{
+		utls.HelloChrome_58, utls.HelloChrome_62, utls.HelloChrome_70, utls.HelloChrome_72,
+		utls.HelloChrome_83, utls.HelloChrome_87, utls.HelloChrome_96, utls.HelloChrome_100,
+		utls.HelloChrome_102, utls.HelloChrome_106_Shuffle, utls.HelloChrome_115_PQ,
+		utls.HelloChrome_120, utls.HelloChrome_120_PQ,
+		utls.HelloFirefox_55, utls.HelloFirefox_56, utls.HelloFirefox_63, utls.HelloFirefox_65,
+		utls.HelloFirefox_99, utls.HelloFirefox_102, utls.HelloFirefox_105, utls.HelloFirefox_120,
+		utls.HelloIOS_11_1, utls.HelloIOS_12_1, utls.HelloIOS_13, utls.HelloIOS_14,
+		utls.HelloAndroid_11_OkHttp,
+		utls.HelloEdge_85, utls.HelloEdge_106,
+		utls.HelloSafari_16_0,
+		utls.Hello360_7_5, utls.Hello360_11_0,
+		utls.HelloQQ_11_1,
+	} {
+		presets[strings.ToLower(id.Str())] = id
+	}
+}
+
+// PresetNames returns sorted names of available utls presets.
+func PresetNames() []string {
+	names := make([]string, 0, len(presets))
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Preset returns profile for named utls preset.
+func Preset(name string) (*Profile, error) {
+	id, ok := presets[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset %q", name)
+	}
+	return &Profile{
+		Name: name,
+		newSpec: func() (*utls.ClientHelloSpec, error) {
+			spec, err := utls.UTLSIdToSpec(id)
+			if err != nil {
+				return nil, err
+			}
+			return &spec, nil
+		},
+	}, nil
+}
+
+// FromRaw creates profile from raw ClientHello: TLS record or bare handshake message.
+func FromRaw(name string, raw []byte) (*Profile, error) {
+	if len(raw) > 0 && raw[0] == 0x01 {
+		// Handshake message, add record header
+		record := []byte{0x16, 0x03, 0x01, byte(len(raw) >> 8), byte(len(raw))}
+		raw = append(record, raw...)
+	}
+	fp := utls.Fingerprinter{AllowBluntMimicry: true}
+	if _, err := fp.FingerprintClientHello(raw); err != nil {
+		return nil, err
+	}
+	return &Profile{
+		Name: name,
+		newSpec: func() (*utls.ClientHelloSpec, error) {
+			return fp.FingerprintClientHello(raw)
+		},
+	}, nil
+}
+
+// FromJSON creates profile from ClientHello spec in utls JSON format.
+func FromJSON(name string, data []byte) (*Profile, error) {
+	newSpec := func() (*utls.ClientHelloSpec, error) {
+		var u utls.ClientHelloSpecJSONUnmarshaler
+		if err := json.Unmarshal(data, &u); err != nil {
+			return nil, err
+		}
+		spec := u.ClientHelloSpec()
+		return &spec, nil
+	}
+	if _, err := newSpec(); err != nil {
+		return nil, err
+	}
+	return &Profile{Name: name, newSpec: newSpec}, nil
+}
+
+// FromHex creates profile from hex dump of raw ClientHello.
+// Whitespace, colons and "0x" prefix are ignored.
+func FromHex(name string, dump string) (*Profile, error) {
+	dump = strings.TrimPrefix(strings.TrimSpace(dump), "0x")
+	dump = strings.NewReplacer(" ", "", "\t", "", "\r", "", "\n", "", ":", "").Replace(dump)
+	raw, err := hex.DecodeString(dump)
+	if err != nil {
+		return nil, err
+	}
+	return FromRaw(name, raw)
+}
+
+// LoadFile reads profile from JSON or hex file.
+func LoadFile(path string) (*Profile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p *Profile
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "{") {
+		p, err = FromJSON(path, data)
+	} else {
+		p, err = FromHex(path, string(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return p, nil
+}
+
+// Get returns profile for preset name or file path.
+func Get(nameOrPath string) (*Profile, error) {
+	if p, err := Preset(nameOrPath); err == nil {
+		return p, nil
+	}
+	if _, err := os.Stat(nameOrPath); err != nil {
+		return nil, fmt.Errorf("%q is neither known preset nor readable file", nameOrPath)
+	}
+	return LoadFile(nameOrPath)
+}
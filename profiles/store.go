@@ -0,0 +1,165 @@
+package profiles
+
+import (
+	"fmt"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Store holds named ClientHello profiles imported from captures. It is safe for concurrent use.
+type Store struct {
+	mu       sync.RWMutex
+	profiles map[string]*Profile
+}
+
+func NewStore() *Store {
+	return &Store{profiles: make(map[string]*Profile)}
+}
+
+// LoadStore imports all files from directory (see Store.ImportFile). Empty dir results in empty store.
+func LoadStore(dir string) (*Store, error) {
+	s := NewStore()
+	if dir == "" {
+		return s, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		if err := s.ImportFile(filepath.Join(dir, e.Name())); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Add stores profile under its name, replacing existing one.
+func (s *Store) Add(p *Profile) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiles[p.Name] = p
+}
+
+// AddRaw stores raw ClientHello (TLS record or handshake message) under name.
+func (s *Store) AddRaw(name string, raw []byte) error {
+	p, err := FromRaw(name, raw)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	s.Add(p)
+	return nil
+}
+
+// AddHex stores hex dump of raw ClientHello under name.
+func (s *Store) AddHex(name string, dump string) error {
+	p, err := FromHex(name, dump)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	s.Add(p)
+	return nil
+}
+
+// AddCapture stores distinct (by JA4) ClientHellos found in pcap or pcapng capture.
+// They are named "<name>-<n>" in order of appearance, or just name if capture has single one.
+func (s *Store) AddCapture(name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hellos, err := ExtractClientHellos(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+
+	var distinct [][]byte
+	seen := make(map[string]bool)
+	for _, raw := range hellos {
+		ch, err := fingerprint.ParseClientHello(raw)
+		if err != nil {
+			continue
+		}
+		ja4 := fingerprint.JA4(ch)
+		if seen[ja4] {
+			continue
+		}
+		seen[ja4] = true
+		distinct = append(distinct, raw)
+	}
+	if len(distinct) == 0 {
+		return fmt.Errorf("%s: no ClientHello found", path)
+	}
+
+	for i, raw := range distinct {
+		helloName := name
+		if len(distinct) > 1 {
+			helloName = fmt.Sprintf("%s-%d", name, i+1)
+		}
+		if err := s.AddRaw(helloName, raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportFile stores profile(s) from file named after it (without extension). Format is chosen by extension:
+// .pcap, .pcapng and .cap are captures, .bin and .raw are raw bytes, .json is utls JSON spec,
+// anything else is hex dump.
+func (s *Store) ImportFile(path string) error {
+	ext := filepath.Ext(path)
+	name := strings.TrimSuffix(filepath.Base(path), ext)
+	switch strings.ToLower(ext) {
+	case ".pcap", ".pcapng", ".cap":
+		return s.AddCapture(name, path)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var p *Profile
+	switch strings.ToLower(ext) {
+	case ".bin", ".raw":
+		p, err = FromRaw(name, data)
+	case ".json":
+		p, err = FromJSON(name, data)
+	default:
+		p, err = FromHex(name, string(data))
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	s.Add(p)
+	return nil
+}
+
+// Names returns sorted names of stored profiles.
+func (s *Store) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.profiles))
+	for name := range s.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Get returns stored profile, preset or profile loaded from file, in that order of preference.
+func (s *Store) Get(nameOrPath string) (*Profile, error) {
+	s.mu.RLock()
+	p, ok := s.profiles[nameOrPath]
+	s.mu.RUnlock()
+	if ok {
+		return p, nil
+	}
+	return Get(nameOrPath)
+}
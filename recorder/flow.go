@@ -0,0 +1,103 @@
+package recorder
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Flow records data of single connection.
+type Flow struct {
+	rec   *Recorder
+	id    string
+	sinks map[string]*sink
+	err   error // first recording error, guarded by rec.mu
+
+	closeOnce sync.Once
+}
+
+// Write records data sent in direction. After the first error nothing is recorded.
+func (f *Flow) Write(direction string, p []byte) error {
+	if len(p) == 0 {
+		return nil
+	}
+	r := f.rec
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f.err != nil {
+		return f.err
+	}
+	s := f.sinks[direction]
+	offset, err := s.write(p)
+	if err == nil {
+		err = r.writeEvent(Event{
+			Flow: f.id,
+			Time: time.Now(),
+			Type: EventData,
+			Data: &Chunk{
+				Direction: direction,
+				File:      s.name,
+				Offset:    offset,
+				Length:    len(p),
+			},
+		})
+	}
+	f.err = err
+	return err
+}
+
+// Err returns the first recording error.
+func (f *Flow) Err() error {
+	f.rec.mu.Lock()
+	defer f.rec.mu.Unlock()
+	return f.err
+}
+
+// Close finishes recording. It is safe to call Close multiple times.
+func (f *Flow) Close() error {
+	var err error
+	f.closeOnce.Do(func() {
+		r := f.rec
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		if r.archive == nil {
+			for _, s := range f.sinks {
+				if errClose := s.w.Close(); err == nil {
+					err = errClose
+				}
+			}
+		}
+		if errEvent := r.writeEvent(Event{Flow: f.id, Time: time.Now(), Type: EventClose}); err == nil {
+			err = errEvent
+		}
+	})
+	return err
+}
+
+// Conns wraps client and server connections so that data read from them is recorded.
+// Closing either of returned connections closes the flow.
+func (f *Flow) Conns(client, server net.Conn) (net.Conn, net.Conn) {
+	return &recordingConn{Conn: client, flow: f, direction: ClientToServer},
+		&recordingConn{Conn: server, flow: f, direction: ServerToClient}
+}
+
+// recordingConn records data read from connection
+type recordingConn struct {
+	net.Conn
+	flow      *Flow
+	direction string
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	// Recording failure does not break connection, it is reported by Flow.Err
+	_ = c.flow.Write(c.direction, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	_ = c.flow.Close()
+	return c.Conn.Close()
+}
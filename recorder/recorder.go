@@ -0,0 +1,197 @@
+// Package recorder writes decrypted connection data to disk.
+//
+// Data read from each side of connection is stored as is, with JSON lines index describing flows
+// and timestamped chunks. Two layouts are supported:
+//
+//   - directory: tcpflow-style file per flow direction ("<flow>_<src>-<dst>") and index.jsonl
+//   - archive: single data file with all chunks and index next to it ("<archive>.idx")
+package recorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Directions of data in flow
+const (
+	ClientToServer = "c2s"
+	ServerToClient = "s2c"
+)
+
+// Index event types
+const (
+	EventOpen  = "open"
+	EventData  = "data"
+	EventClose = "close"
+)
+
+// indexFileName is the name of index in directory layout
+const indexFileName = "index.jsonl"
+
+// Event is an index record.
+type Event struct {
+	Flow   string    `json:"flow"`
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	Client string    `json:"client,omitempty"` // open only
+	Target string    `json:"target,omitempty"` // open only
+	Data   *Chunk    `json:"data,omitempty"`   // data only
+}
+
+// Chunk locates data read at once from one side of connection.
+type Chunk struct {
+	Direction string `json:"direction"`
+	File      string `json:"file"`
+	Offset    int64  `json:"offset"`
+	Length    int    `json:"length"`
+}
+
+// sink is a data file with write position
+type sink struct {
+	w      io.WriteCloser
+	name   string
+	offset int64
+}
+
+func (s *sink) write(p []byte) (int64, error) {
+	offset := s.offset
+	n, err := s.w.Write(p)
+	s.offset += int64(n)
+	return offset, err
+}
+
+// Recorder creates flows and writes their data. It is safe for concurrent use.
+type Recorder struct {
+	dir     string // directory layout if set
+	archive *sink  // archive layout if set
+
+	// Flow IDs are "<recorder start time>-<sequence number>", so they are unique across restarts
+	idPrefix string
+
+	mu      sync.Mutex
+	index   io.WriteCloser
+	enc     *json.Encoder
+	lastSeq uint64
+}
+
+// NewDirRecorder creates recorder writing files to directory (created if missing).
+func NewDirRecorder(dir string) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	index, err := openAppend(filepath.Join(dir, indexFileName))
+	if err != nil {
+		return nil, err
+	}
+	return newRecorder(dir, nil, index), nil
+}
+
+// NewArchiveRecorder creates recorder writing data to single file and index to "<path>.idx".
+func NewArchiveRecorder(path string) (*Recorder, error) {
+	data, err := openAppend(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := data.Stat()
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	index, err := openAppend(path + ".idx")
+	if err != nil {
+		data.Close()
+		return nil, err
+	}
+	archive := &sink{w: data, name: filepath.Base(path), offset: info.Size()}
+	return newRecorder("", archive, index), nil
+}
+
+func newRecorder(dir string, archive *sink, index io.WriteCloser) *Recorder {
+	return &Recorder{
+		dir:      dir,
+		archive:  archive,
+		index:    index,
+		enc:      json.NewEncoder(index),
+		idPrefix: time.Now().Format("20060102T150405"),
+	}
+}
+
+func openAppend(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+}
+
+// NewFlow starts recording of connection from client to target (addresses as "host:port").
+func (r *Recorder) NewFlow(client, target string) (*Flow, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.lastSeq++
+	f := &Flow{rec: r, id: fmt.Sprintf("%s-%d", r.idPrefix, r.lastSeq)}
+	if r.archive != nil {
+		f.sinks = map[string]*sink{ClientToServer: r.archive, ServerToClient: r.archive}
+	} else {
+		c2s, err := r.newFlowSink(f.id, client, target)
+		if err != nil {
+			return nil, err
+		}
+		s2c, err := r.newFlowSink(f.id, target, client)
+		if err != nil {
+			c2s.w.Close()
+			return nil, err
+		}
+		f.sinks = map[string]*sink{ClientToServer: c2s, ServerToClient: s2c}
+	}
+	err := r.writeEvent(Event{
+		Flow:   f.id,
+		Time:   time.Now(),
+		Type:   EventOpen,
+		Client: client,
+		Target: target,
+	})
+	if err != nil {
+		if r.archive == nil {
+			for _, s := range f.sinks {
+				_ = s.w.Close()
+			}
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+func (r *Recorder) newFlowSink(id string, src, dst string) (*sink, error) {
+	name := fmt.Sprintf("%s_%s-%s", id, fileNamePart(src), fileNamePart(dst))
+	w, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	return &sink{w: w, name: name}, nil
+}
+
+// fileNamePart makes address usable in file name ("host:port" becomes "host.port", as in tcpflow)
+func fileNamePart(addr string) string {
+	return strings.NewReplacer(":", ".", "[", "", "]", "", "/", "_", `\`, "_").Replace(addr)
+}
+
+// writeEvent MUST be called with r.mu held
+func (r *Recorder) writeEvent(e Event) error {
+	return r.enc.Encode(e)
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	err := r.index.Close()
+	if r.archive != nil {
+		if errData := r.archive.w.Close(); err == nil {
+			err = errData
+		}
+	}
+	return err
+}
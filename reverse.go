@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"net"
+	"time"
+)
+
+// serveReverse accepts connections on l and tunnels them to fixed upstream ("host:port").
+// Connections go through the same rules and hijackers as CONNECT ones, with upstream as target.
+func serveReverse(
+	l net.Listener,
+	upstream string,
+	connect func(client net.Addr, target, serverName string) (func(net.Conn), error),
+) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go func() {
+			handler, _ := connect(conn.RemoteAddr(), upstream, "") // Error is logged by connect
+			if handler == nil {
+				_ = conn.Close()
+				return
+			}
+			handler(conn)
+		}()
+	}
+}
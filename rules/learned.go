@@ -0,0 +1,195 @@
+package rules
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LearnedEntry is a (client, host) pair for which interception failed
+// because client rejected forged certificate.
+type LearnedEntry struct {
+	Client string    `json:"client"`
+	Host   string    `json:"host"`
+	Time   time.Time `json:"time"`
+	Reason string    `json:"reason"`
+}
+
+// Learned is a concurrency-safe set of (client, host) pairs which should be passed through.
+// Pair is learned once client rejects certificate threshold times within window,
+// and is forgotten after ttl (if set). If file path is set, set is saved to it on every change.
+type Learned struct {
+	path      string
+	threshold int
+	window    time.Duration
+	ttl       time.Duration
+	now       func() time.Time
+
+	mu         sync.Mutex
+	entries    map[string]LearnedEntry
+	rejections map[string][]time.Time // recent rejections of pairs not learned yet
+}
+
+// LoadLearned reads learned set from file. Missing file results in empty set.
+// Empty path results in empty set which is never saved. ttl of 0 keeps entries forever.
+func LoadLearned(path string, threshold int, window, ttl time.Duration) (*Learned, error) {
+	l := &Learned{
+		path:       path,
+		threshold:  max(threshold, 1),
+		window:     window,
+		ttl:        ttl,
+		now:        time.Now,
+		entries:    make(map[string]LearnedEntry),
+		rejections: make(map[string][]time.Time),
+	}
+	if path == "" {
+		return l, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []LearnedEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if !l.expired(e) {
+			l.entries[learnedKey(e.Client, e.Host)] = e
+		}
+	}
+	return l, nil
+}
+
+func learnedKey(client, host string) string {
+	return client + "|" + strings.ToLower(host)
+}
+
+// Reject records rejection of certificate by client. It returns true if pair is learned as a result.
+func (l *Learned) Reject(client, host, reason string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := learnedKey(client, host)
+	if e, ok := l.entries[key]; ok && !l.expired(e) {
+		return false, nil
+	}
+	now := l.now()
+	recent := l.rejections[key][:0]
+	for _, t := range l.rejections[key] {
+		if now.Sub(t) < l.window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, now)
+	if len(recent) < l.threshold {
+		l.rejections[key] = recent
+		return false, nil
+	}
+	delete(l.rejections, key)
+	l.entries[key] = LearnedEntry{
+		Client: client,
+		Host:   strings.ToLower(host),
+		Time:   now,
+		Reason: reason,
+	}
+	return true, l.save()
+}
+
+// Remove forgets pair. It returns false if pair was not known.
+func (l *Learned) Remove(client, host string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	key := learnedKey(client, host)
+	delete(l.rejections, key)
+	if _, ok := l.entries[key]; !ok {
+		return false, nil
+	}
+	delete(l.entries, key)
+	return true, l.save()
+}
+
+// Contains reports whether pair is known.
+func (l *Learned) Contains(client, host string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	e, ok := l.entries[learnedKey(client, host)]
+	return ok && !l.expired(e)
+}
+
+// Entries returns all known pairs ordered by time of learning.
+func (l *Learned) Entries() []LearnedEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.sortedEntries()
+}
+
+// ServeHTTP shows known pairs as JSON. DELETE request with client and host query parameters removes pair.
+func (l *Learned) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodDelete:
+		removed, err := l.Remove(r.URL.Query().Get("client"), r.URL.Query().Get("host"))
+		switch {
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		case !removed:
+			http.Error(w, "no such entry", http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	default:
+		w.Header().Set("Allow", "GET, HEAD, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(l.Entries())
+}
+
+// sortedEntries MUST be called with l.mu held
+func (l *Learned) sortedEntries() []LearnedEntry {
+	entries := make([]LearnedEntry, 0, len(l.entries))
+	for key, e := range l.entries {
+		if l.expired(e) {
+			delete(l.entries, key)
+			continue
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Time.Before(entries[j].Time) })
+	return entries
+}
+
+func (l *Learned) expired(e LearnedEntry) bool {
+	return l.ttl > 0 && l.now().Sub(e.Time) >= l.ttl
+}
+
+// save MUST be called with l.mu held
+func (l *Learned) save() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.sortedEntries(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
@@ -0,0 +1,112 @@
+package rules
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testClock is manually advanced clock
+type testClock struct{ t time.Time }
+
+func (c *testClock) now() time.Time { return c.t }
+
+func (c *testClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLearned(t *testing.T, path string, threshold int, window, ttl time.Duration) (*Learned, *testClock) {
+	t.Helper()
+	l, err := LoadLearned(path, threshold, window, ttl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: time.Now()}
+	l.now = clock.now
+	return l, clock
+}
+
+func TestLearnedThreshold(t *testing.T) {
+	l, clock := newTestLearned(t, "", 3, 10*time.Minute, 0)
+	reject := func() bool {
+		t.Helper()
+		learned, err := l.Reject("10.0.0.2", "Example.com", "bad certificate")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return learned
+	}
+
+	if reject() || reject() {
+		t.Fatal("learned before threshold")
+	}
+	// Rejections outside window are forgotten
+	clock.advance(11 * time.Minute)
+	if reject() || reject() {
+		t.Fatal("learned with rejections outside window")
+	}
+	if l.Contains("10.0.0.2", "example.com") {
+		t.Fatal("pair is known before threshold")
+	}
+	clock.advance(time.Minute)
+	if !reject() {
+		t.Fatal("not learned at threshold")
+	}
+	if reject() {
+		t.Error("known pair learned again")
+	}
+	if !l.Contains("10.0.0.2", "EXAMPLE.COM") || l.Contains("10.0.0.3", "example.com") {
+		t.Error("wrong pairs are known")
+	}
+}
+
+func TestLearnedTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learned.json")
+	l, clock := newTestLearned(t, path, 1, time.Minute, time.Hour)
+	if learned, err := l.Reject("10.0.0.2", "example.com", "unknown ca"); !learned || err != nil {
+		t.Fatalf("Reject = %v, %v", learned, err)
+	}
+
+	reloaded, _ := newTestLearned(t, path, 1, time.Minute, time.Hour)
+	if !reloaded.Contains("10.0.0.2", "example.com") {
+		t.Error("pair is not persisted")
+	}
+
+	clock.advance(time.Hour)
+	if l.Contains("10.0.0.2", "example.com") || len(l.Entries()) != 0 {
+		t.Error("expired pair is known")
+	}
+	if learned, _ := l.Reject("10.0.0.2", "example.com", "unknown ca"); !learned {
+		t.Error("expired pair is not learned again")
+	}
+}
+
+func TestLearnedRemove(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "learned.json")
+	l, _ := newTestLearned(t, path, 1, time.Minute, 0)
+	_, _ = l.Reject("10.0.0.2", "example.com", "bad certificate")
+	_, _ = l.Reject("10.0.0.2", "example.org", "bad certificate")
+
+	tests := []struct {
+		method string
+		query  string
+		status int
+	}{
+		{http.MethodGet, "", http.StatusOK},
+		{http.MethodDelete, "?client=10.0.0.2&host=Example.com", http.StatusNoContent},
+		{http.MethodDelete, "?client=10.0.0.2&host=example.com", http.StatusNotFound},
+		{http.MethodPost, "", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, httptest.NewRequest(tt.method, "/learned"+tt.query, nil))
+		if w.Code != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.query, w.Code, tt.status)
+		}
+	}
+
+	reloaded, _ := newTestLearned(t, path, 1, time.Minute, 0)
+	if reloaded.Contains("10.0.0.2", "example.com") || !reloaded.Contains("10.0.0.2", "example.org") {
+		t.Errorf("entries after removal: %+v", reloaded.Entries())
+	}
+}
@@ -0,0 +1,227 @@
+// Package rules implements per-host interception policy.
+//
+// Rules file contains one rule per line: action followed by target pattern.
+// Empty lines and lines starting with '#' are ignored. First matching rule wins.
+//
+//	# action     pattern
+//	passthrough  *.apple.com
+//	mitm         api.example.com:443
+//	block        10.0.0.0/8
+//	passthrough  /^push\d+\.example\.net$/
+//	passthrough  *:5222
+//
+// Pattern is an exact host name, wildcard ('*' matches any characters, dots included),
+// regular expression enclosed in slashes or CIDR (matches IP targets only).
+// Any pattern may be followed by ":port" (IPv6 CIDR must be enclosed in brackets then).
+//
+// mitm rule may have third field naming ClientHello profile to present upstream
+// (see package profiles):
+//
+//	mitm         *.example.org  chrome-120
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Actions
+const (
+	ActionMITM        = "mitm"
+	ActionPassthrough = "passthrough"
+	ActionBlock       = "block"
+)
+
+// Rule maps target pattern to action.
+type Rule struct {
+	Action  string
+	Pattern string
+	Profile string // ClientHello profile, mitm only
+
+	host *regexp.Regexp // nil if rule matches any host
+	cidr *net.IPNet
+	port int // 0 if rule matches any port
+	line int
+}
+
+func (r Rule) String() string {
+	if r.Profile != "" {
+		return fmt.Sprintf("line %d: %s %s %s", r.line, r.Action, r.Pattern, r.Profile)
+	}
+	return fmt.Sprintf("line %d: %s %s", r.line, r.Action, r.Pattern)
+}
+
+// Rules is an ordered list of rules.
+type Rules struct {
+	rules []Rule
+}
+
+// Load reads rules from file. Empty path results in empty rule list.
+func Load(path string) (*Rules, error) {
+	if path == "" {
+		return &Rules{}, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads rules in text format.
+func Parse(r io.Reader) (*Rules, error) {
+	rs := &Rules{}
+	sc := bufio.NewScanner(r)
+	lineNum := 0
+	for sc.Scan() {
+		lineNum++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 && len(fields) != 3 {
+			return nil, fmt.Errorf("line %d: expected action, pattern and optional profile", lineNum)
+		}
+		rule, err := NewRule(fields[0], fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNum, err)
+		}
+		if len(fields) == 3 {
+			if rule.Action != ActionMITM {
+				return nil, fmt.Errorf("line %d: profile is only allowed for %s rules", lineNum, ActionMITM)
+			}
+			rule.Profile = fields[2]
+		}
+		rule.line = lineNum
+		rs.rules = append(rs.rules, rule)
+	}
+	return rs, sc.Err()
+}
+
+// NewRule creates rule from action and pattern.
+func NewRule(action, pattern string) (Rule, error) {
+	switch action {
+	case ActionMITM, ActionPassthrough, ActionBlock:
+	default:
+		return Rule{}, fmt.Errorf("unknown action %q", action)
+	}
+	rule := Rule{Action: action, Pattern: pattern}
+
+	hostPattern, port, err := splitPort(pattern)
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.port = port
+
+	switch {
+	case len(hostPattern) >= 2 && strings.HasPrefix(hostPattern, "/") && strings.HasSuffix(hostPattern, "/"):
+		rule.host, err = regexp.Compile(hostPattern[1 : len(hostPattern)-1])
+		if err != nil {
+			return Rule{}, err
+		}
+	case strings.Contains(hostPattern, "/"):
+		_, rule.cidr, err = net.ParseCIDR(hostPattern)
+		if err != nil {
+			return Rule{}, err
+		}
+	case hostPattern == "*":
+	default:
+		quoted := regexp.QuoteMeta(strings.ToLower(hostPattern))
+		rule.host = regexp.MustCompile("^" + strings.ReplaceAll(quoted, `\*`, ".*") + "$")
+	}
+	return rule, nil
+}
+
+// splitPort separates optional ":port" suffix from pattern
+func splitPort(pattern string) (string, int, error) {
+	var host, port string
+	switch {
+	case strings.HasPrefix(pattern, "/"):
+		end := strings.LastIndex(pattern, "/")
+		host, port = pattern[:end+1], strings.TrimPrefix(pattern[end+1:], ":")
+	case strings.HasPrefix(pattern, "["):
+		end := strings.Index(pattern, "]")
+		if end < 0 {
+			return "", 0, fmt.Errorf("missing ']' in %q", pattern)
+		}
+		host, port = pattern[1:end], strings.TrimPrefix(pattern[end+1:], ":")
+	case strings.Count(pattern, ":") == 1:
+		host, port, _ = strings.Cut(pattern, ":")
+	default:
+		host = pattern
+	}
+	if port == "" || port == "*" {
+		return host, 0, nil
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil || p <= 0 || p > 65535 {
+		return "", 0, fmt.Errorf("invalid port %q", port)
+	}
+	return host, p, nil
+}
+
+func (r Rule) matches(host string, port int) bool {
+	if r.port != 0 && r.port != port {
+		return false
+	}
+	if r.cidr != nil {
+		ip := net.ParseIP(host)
+		return ip != nil && r.cidr.Contains(ip)
+	}
+	return r.host == nil || r.host.MatchString(host)
+}
+
+// Match returns the first rule matching target ("host:port"), if any.
+func (rs *Rules) Match(target string) (Rule, bool) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		host = target
+	}
+	host = strings.ToLower(host)
+	port, _ := strconv.Atoi(portStr)
+	for _, r := range rs.rules {
+		if r.matches(host, port) {
+			return r, true
+		}
+	}
+	return Rule{}, false
+}
+
+// Action returns action of the first rule matching target or defaultAction if none match.
+func (rs *Rules) Action(target, defaultAction string) string {
+	if r, ok := rs.Match(target); ok {
+		return r.Action
+	}
+	return defaultAction
+}
+
+// Uses reports whether any rule has given action.
+func (rs *Rules) Uses(action string) bool {
+	for _, r := range rs.rules {
+		if r.Action == action {
+			return true
+		}
+	}
+	return false
+}
+
+// Profiles returns distinct profile names used by rules.
+func (rs *Rules) Profiles() []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, r := range rs.rules {
+		if r.Profile != "" && !seen[r.Profile] {
+			seen[r.Profile] = true
+			res = append(res, r.Profile)
+		}
+	}
+	return res
+}
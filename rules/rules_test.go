@@ -0,0 +1,152 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSplitPort(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		port    int
+		wantErr bool
+	}{
+		{pattern: "example.com", host: "example.com"},
+		{pattern: "example.com:443", host: "example.com", port: 443},
+		{pattern: "example.com:*", host: "example.com"},
+		{pattern: "*:5222", host: "*", port: 5222},
+		{pattern: "/^a:b$/", host: "/^a:b$/"},
+		{pattern: "/^a\\d+$/:8443", host: "/^a\\d+$/", port: 8443},
+		{pattern: "10.0.0.0/8", host: "10.0.0.0/8"},
+		{pattern: "10.0.0.0/8:22", host: "10.0.0.0/8", port: 22},
+		{pattern: "[::1]", host: "::1"},
+		{pattern: "[::1]:443", host: "::1", port: 443},
+		{pattern: "[2001:db8::/32]:443", host: "2001:db8::/32", port: 443},
+		{pattern: "2001:db8::1", host: "2001:db8::1"},
+		{pattern: "[::1", wantErr: true},
+		{pattern: "[::1]x", wantErr: true},
+		{pattern: "example.com:0", wantErr: true},
+		{pattern: "example.com:65536", wantErr: true},
+		{pattern: "example.com:https", wantErr: true},
+		{pattern: "/a/:-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			host, port, err := splitPort(tt.pattern)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("splitPort(%q) = %q, %d, want error", tt.pattern, host, port)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("splitPort(%q): %v", tt.pattern, err)
+			}
+			if host != tt.host || port != tt.port {
+				t.Errorf("splitPort(%q) = %q, %d, want %q, %d", tt.pattern, host, port, tt.host, tt.port)
+			}
+		})
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		err   string
+	}{
+		{"unknown action", "allow example.com", "line 1: unknown action"},
+		{"missing pattern", "\n\nmitm", "line 3: expected action"},
+		{"extra fields", "mitm example.com chrome-120 extra", "line 1: expected action"},
+		{"profile on passthrough", "passthrough example.com chrome-120", "line 1: profile is only allowed"},
+		{"bad regex", "block /a(/", "line 1: error parsing regexp"},
+		{"bad cidr", "block 10.0.0.0/33", "line 1: invalid CIDR"},
+		{"bad port", "# comment\nblock example.com:99999", "line 2: invalid port"},
+		{"unclosed bracket", "block [::1:443", "line 1: missing ']'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.rules))
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("Parse error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+const testRules = `
+# comment
+block        ads.example.com
+mitm         api.example.com:443   chrome-120
+passthrough  *.apple.com
+passthrough  /^push\d+\.example\.net$/
+block        10.0.0.0/8
+passthrough  [2001:db8::/32]:443
+mitm         [::1]:8443
+passthrough  *:5222
+mitm         *.example.com
+`
+
+func TestMatch(t *testing.T) {
+	rs, err := Parse(strings.NewReader(testRules))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		target  string
+		action  string
+		line    int
+		profile string
+	}{
+		{target: "ads.example.com:443", action: ActionBlock, line: 3},
+		{target: "ADS.Example.COM:80", action: ActionBlock, line: 3},
+		{target: "api.example.com:443", action: ActionMITM, line: 4, profile: "chrome-120"},
+		{target: "api.example.com:80", action: ActionMITM, line: 11},
+		{target: "www.apple.com:443", action: ActionPassthrough, line: 5},
+		{target: "a.b.apple.com:443", action: ActionPassthrough, line: 5},
+		{target: "apple.com:443"},
+		{target: "push12.example.net:443", action: ActionPassthrough, line: 6},
+		{target: "push.example.net:443"},
+		{target: "10.1.2.3:443", action: ActionBlock, line: 7},
+		{target: "11.1.2.3:443"},
+		{target: "[2001:db8::5]:443", action: ActionPassthrough, line: 8},
+		{target: "[2001:db8::5]:80"},
+		{target: "[::1]:8443", action: ActionMITM, line: 9},
+		{target: "[::1]:443"},
+		{target: "chat.example.org:5222", action: ActionPassthrough, line: 10},
+		{target: "www.example.com", action: ActionMITM, line: 11},
+		{target: "example.com:443"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			r, ok := rs.Match(tt.target)
+			if ok != (tt.action != "") {
+				t.Fatalf("Match(%q) = %v, %v, want action %q", tt.target, r, ok, tt.action)
+			}
+			if r.Action != tt.action || r.line != tt.line || r.Profile != tt.profile {
+				t.Errorf("Match(%q) = %v, want line %d: %s (profile %q)", tt.target, r, tt.line, tt.action, tt.profile)
+			}
+			if got := rs.Action(tt.target, "default"); ok && got != tt.action || !ok && got != "default" {
+				t.Errorf("Action(%q) = %q", tt.target, got)
+			}
+		})
+	}
+}
+
+func TestUsesAndProfiles(t *testing.T) {
+	rs, err := Parse(strings.NewReader(testRules + "mitm other.test chrome-120\nmitm third.test firefox-121\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rs.Uses(ActionBlock) || !rs.Uses(ActionMITM) || rs.Uses("none") {
+		t.Error("Uses reports wrong actions")
+	}
+	if got := strings.Join(rs.Profiles(), ","); got != "chrome-120,firefox-121" {
+		t.Errorf("Profiles = %q", got)
+	}
+	empty, err := Load("")
+	if err != nil || empty.Uses(ActionMITM) {
+		t.Errorf("Load(\"\") = %v, %v", empty, err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"github.com/elazarl/goproxy"
+	"github.com/fedosgad/mirror_proxy/rules"
+	"github.com/fedosgad/mirror_proxy/socks5"
+	"net"
+	"net/http"
+)
+
+// newSOCKSServer returns SOCKS5 server passing CONNECT requests to the same hijackers as HTTP CONNECT ones.
+func newSOCKSServer(
+	lc ListenerConfig,
+	verbose bool,
+	p *goproxy.ProxyHttpServer,
+	policy *rules.Rules,
+	actionFor func(host string, ctx *goproxy.ProxyCtx) string,
+	hijackFunc func(action string, tunnel bool) func(*http.Request, net.Conn, *goproxy.ProxyCtx),
+	dialFirst func(target string) (func(*http.Request, net.Conn, *goproxy.ProxyCtx), error),
+) *socks5.Server {
+	tunnelConnect := getTunnelConnectFunc(p, "SOCKS5", actionFor, hijackFunc, dialFirst)
+	connect := func(client net.Addr, target string) (func(net.Conn), error) {
+		return tunnelConnect(client, target, "")
+	}
+	var allowUDP socks5.AllowUDPFunc
+	if lc.SOCKSUDP {
+		allowUDP = func(_ net.Addr, target string) bool {
+			return policy.Action(target, lc.Mode) != rules.ActionBlock
+		}
+	}
+	return socks5.NewServer(lc.SOCKSUser, lc.SOCKSPassword, connect, allowUDP, verbose)
+}
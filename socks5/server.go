@@ -0,0 +1,325 @@
+// Package socks5 implements SOCKS5 server (RFC 1928) with optional username/password
+// authentication (RFC 1929).
+//
+// CONNECT requests are handed over to caller, UDP ASSOCIATE datagrams are relayed as is.
+package socks5
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Protocol constants
+const (
+	socksVersion    = 5
+	userPassVersion = 1
+
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	cmdConnect      = 1
+	cmdBind         = 2
+	cmdUDPAssociate = 3
+
+	atypIPv4   = 1
+	atypDomain = 3
+	atypIPv6   = 4
+)
+
+// Reply codes
+const (
+	repSucceeded           = 0x00
+	repGeneralFailure      = 0x01
+	repNotAllowed          = 0x02
+	repNetworkUnreachable  = 0x03
+	repHostUnreachable     = 0x04
+	repConnectionRefused   = 0x05
+	repTTLExpired          = 0x06
+	repCommandNotSupported = 0x07
+	repAddressNotSupported = 0x08
+)
+
+// handshakeTimeout limits time client may spend before sending request
+const handshakeTimeout = 30 * time.Second
+
+var errUnsupportedAddress = errors.New("unsupported address type")
+
+// ConnectFunc returns handler of CONNECT request from client to target ("host:port"),
+// or nil if connection is not allowed. Error (e.g. of dialing target) is reported to client.
+// Handler is called after client is told that connection succeeded and owns the connection.
+type ConnectFunc func(client net.Addr, target string) (func(conn net.Conn), error)
+
+// AllowUDPFunc reports whether datagrams from client may be sent to target ("host:port").
+type AllowUDPFunc func(client net.Addr, target string) bool
+
+type Server struct {
+	username string
+	password string
+	connect  ConnectFunc
+	allowUDP AllowUDPFunc
+	verbose  bool
+}
+
+// NewServer creates server. Authentication is required if username is not empty.
+// UDP ASSOCIATE is not supported if allowUDP is nil.
+func NewServer(username, password string, connect ConnectFunc, allowUDP AllowUDPFunc, verbose bool) *Server {
+	return &Server{
+		username: username,
+		password: password,
+		connect:  connect,
+		allowUDP: allowUDP,
+		verbose:  verbose,
+	}
+}
+
+// Serve accepts connections on l and serves them. It returns when l fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) logf(msg string, argv ...interface{}) {
+	if s.verbose {
+		log.Printf("SOCKS5: "+msg, argv...)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	keepOpen := false
+	defer func() {
+		if !keepOpen {
+			_ = conn.Close()
+		}
+	}()
+
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	if err := s.authenticate(conn); err != nil {
+		s.logf("%s: authentication: %v", conn.RemoteAddr(), err)
+		return
+	}
+	cmd, target, err := readRequest(conn)
+	if errors.Is(err, errUnsupportedAddress) {
+		_ = writeReply(conn, repAddressNotSupported, nil)
+		return
+	}
+	if err != nil {
+		s.logf("%s: request: %v", conn.RemoteAddr(), err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	switch {
+	case cmd == cmdConnect:
+		handler, err := s.connect(conn.RemoteAddr(), target)
+		if err != nil {
+			s.logf("%s: connect to %s: %v", conn.RemoteAddr(), target, err)
+			_ = writeReply(conn, replyCode(err), nil)
+			return
+		}
+		if handler == nil {
+			_ = writeReply(conn, repNotAllowed, nil)
+			return
+		}
+		if err := writeReply(conn, repSucceeded, conn.LocalAddr()); err != nil {
+			return
+		}
+		keepOpen = true
+		handler(conn)
+	case cmd == cmdUDPAssociate && s.allowUDP != nil:
+		s.udpAssociate(conn)
+	default:
+		_ = writeReply(conn, repCommandNotSupported, nil)
+	}
+}
+
+// replyCode maps dial error to reply code
+func replyCode(err error) byte {
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return repConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return repNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH) || errors.As(err, &dnsErr):
+		return repHostUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return repTTLExpired
+	default:
+		return repGeneralFailure
+	}
+}
+
+// authenticate negotiates authentication method and checks credentials
+func (s *Server) authenticate(conn net.Conn) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return err
+	}
+	method := byte(methodNoAuth)
+	if s.username != "" {
+		method = methodUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		if m == method {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		_, _ = conn.Write([]byte{socksVersion, methodNoAcceptable})
+		return fmt.Errorf("no acceptable authentication method in %v", methods)
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return err
+	}
+	if method == methodNoAuth {
+		return nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	username, password, err := readCredentials(conn)
+	if err != nil {
+		return err
+	}
+	ok := subtle.ConstantTimeCompare([]byte(username), []byte(s.username)) == 1 &&
+		subtle.ConstantTimeCompare([]byte(password), []byte(s.password)) == 1
+	status := byte(0)
+	if !ok {
+		status = 1
+	}
+	if _, err := conn.Write([]byte{userPassVersion, status}); err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("invalid credentials for user %q", username)
+	}
+	return nil
+}
+
+func readCredentials(r io.Reader) (string, string, error) {
+	buf := make([]byte, 2)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", "", err
+	}
+	if buf[0] != userPassVersion {
+		return "", "", fmt.Errorf("unsupported authentication version %d", buf[0])
+	}
+	username := make([]byte, buf[1])
+	if _, err := io.ReadFull(r, username); err != nil {
+		return "", "", err
+	}
+	if _, err := io.ReadFull(r, buf[:1]); err != nil {
+		return "", "", err
+	}
+	password := make([]byte, buf[0])
+	if _, err := io.ReadFull(r, password); err != nil {
+		return "", "", err
+	}
+	return string(username), string(password), nil
+}
+
+// readRequest reads VER CMD RSV ATYP DST.ADDR DST.PORT
+func readRequest(r io.Reader) (byte, string, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, "", err
+	}
+	if header[0] != socksVersion {
+		return 0, "", fmt.Errorf("unsupported version %d", header[0])
+	}
+	target, err := readAddr(r)
+	return header[1], target, err
+}
+
+// readAddr reads ATYP DST.ADDR DST.PORT and returns "host:port"
+func readAddr(r io.Reader) (string, error) {
+	atyp := make([]byte, 1)
+	if _, err := io.ReadFull(r, atyp); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp[0] == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", errUnsupportedAddress
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port[0])<<8|int(port[1]))), nil
+}
+
+// appendAddr appends ATYP ADDR PORT of addr (zero IPv4 address if addr is not IP one)
+func appendAddr(b []byte, addr net.Addr) []byte {
+	var ip net.IP
+	var port int
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip, port = a.IP, a.Port
+	case *net.UDPAddr:
+		ip, port = a.IP, a.Port
+	}
+	if ip4 := ip.To4(); ip4 != nil || ip == nil {
+		if ip4 == nil {
+			ip4 = net.IPv4zero.To4()
+		}
+		b = append(b, atypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, atypIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return append(b, byte(port>>8), byte(port))
+}
+
+// writeReply writes VER REP RSV ATYP BND.ADDR BND.PORT
+func writeReply(w io.Writer, rep byte, bound net.Addr) error {
+	_, err := w.Write(appendAddr([]byte{socksVersion, rep, 0}, bound))
+	return err
+}
@@ -0,0 +1,147 @@
+package socks5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+)
+
+// maxDatagramSize is the largest UDP payload
+const maxDatagramSize = 65535
+
+// udpAssociate relays datagrams between client and targets while control connection is open.
+// Datagrams are sent directly, not through upstream proxy.
+func (s *Server) udpAssociate(conn net.Conn) {
+	local, localOK := conn.LocalAddr().(*net.TCPAddr)
+	remote, remoteOK := conn.RemoteAddr().(*net.TCPAddr)
+	if !localOK || !remoteOK {
+		// E.g. Unix socket, client address can not be checked
+		_ = writeReply(conn, repCommandNotSupported, nil)
+		return
+	}
+	localIP, clientIP := local.IP, remote.IP
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		s.logf("%s: UDP relay: %v", conn.RemoteAddr(), err)
+		_ = writeReply(conn, repGeneralFailure, nil)
+		return
+	}
+	defer relay.Close()
+	out, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		s.logf("%s: UDP relay: %v", conn.RemoteAddr(), err)
+		_ = writeReply(conn, repGeneralFailure, nil)
+		return
+	}
+	defer out.Close()
+
+	if err := writeReply(conn, repSucceeded, relay.LocalAddr()); err != nil {
+		return
+	}
+	s.logf("%s: UDP relay on %s", conn.RemoteAddr(), relay.LocalAddr())
+
+	// Client address is learned from its first datagram
+	var clientAddr atomic.Pointer[net.UDPAddr]
+	dsts := &destinations{m: make(map[netip.AddrPort]struct{})}
+	go s.relayToTargets(conn.RemoteAddr(), clientIP, relay, out, &clientAddr, dsts)
+	go relayToClient(relay, out, &clientAddr, dsts)
+
+	// Association ends with control connection
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+// relayToTargets sends client datagrams to their targets
+func (s *Server) relayToTargets(
+	client net.Addr,
+	clientIP net.IP,
+	relay, out *net.UDPConn,
+	clientAddr *atomic.Pointer[net.UDPAddr],
+	dsts *destinations,
+) {
+	resolved := make(map[string]*net.UDPAddr)
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, src, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !src.IP.Equal(clientIP) {
+			continue // Not from associated client
+		}
+		clientAddr.Store(src)
+
+		// RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT DATA
+		if n < 4 || buf[2] != 0 {
+			continue // Fragmentation is not supported
+		}
+		r := bytes.NewReader(buf[3:n])
+		target, err := readAddr(r)
+		if err != nil {
+			continue
+		}
+		addr, ok := resolved[target]
+		if !ok {
+			if !s.allowUDP(client, target) {
+				s.logf("%s: UDP to %s is not allowed", client, target)
+			} else if addr, err = net.ResolveUDPAddr("udp", target); err != nil {
+				s.logf("%s: UDP to %s: %v", client, target, err)
+			}
+			resolved[target] = addr // nil if target is not allowed or not resolved
+		}
+		if addr == nil {
+			continue
+		}
+		data := buf[n-r.Len() : n]
+		dsts.add(addr)
+		_, _ = out.WriteToUDP(data, addr)
+	}
+}
+
+// relayToClient sends datagrams from targets to client. Datagrams from other sources are dropped.
+func relayToClient(relay, out *net.UDPConn, clientAddr *atomic.Pointer[net.UDPAddr], dsts *destinations) {
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, src, err := out.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		dst := clientAddr.Load()
+		if dst == nil || !dsts.contains(src) {
+			continue
+		}
+		if ip4 := src.IP.To4(); ip4 != nil {
+			src.IP = ip4 // Reply with IPv4 address even if socket is dual stack
+		}
+		datagram := appendAddr([]byte{0, 0, 0}, src)
+		_, _ = relay.WriteToUDP(append(datagram, buf[:n]...), dst)
+	}
+}
+
+// destinations is a set of addresses client has sent datagrams to
+type destinations struct {
+	mu sync.Mutex
+	m  map[netip.AddrPort]struct{}
+}
+
+func (d *destinations) add(addr *net.UDPAddr) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.m[udpAddrPort(addr)] = struct{}{}
+}
+
+func (d *destinations) contains(addr *net.UDPAddr) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	_, ok := d.m[udpAddrPort(addr)]
+	return ok
+}
+
+// udpAddrPort returns addr with IPv4-mapped IPv6 address unmapped, as dual stack socket may report it either way
+func udpAddrPort(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"github.com/elazarl/goproxy"
+	"github.com/fedosgad/mirror_proxy/har"
 	"github.com/fedosgad/mirror_proxy/hijackers"
+	"github.com/fedosgad/mirror_proxy/recorder"
+	"github.com/fedosgad/mirror_proxy/rules"
 	"github.com/fedosgad/mirror_proxy/utils"
 	"io"
 	"net"
@@ -10,7 +14,17 @@ import (
 	"sync"
 )
 
-func getTLSHijackFunc(hj hijackers.Hijacker) func(*http.Request, net.Conn, *goproxy.ProxyCtx) {
+func getTLSHijackFunc(
+	hj hijackers.Hijacker,
+	learned *rules.Learned,
+	rec *recorder.Recorder,
+	harWriter *har.Writer,
+	tunnel bool, // client's tunnel request is already answered (e.g. by SOCKS server)
+) func(*http.Request, net.Conn, *goproxy.ProxyCtx) {
+	getConns := hj.GetConns
+	if tunnel {
+		getConns = hj.GetTunnelConns
+	}
 	return func(req *http.Request, connL net.Conn, ctx *goproxy.ProxyCtx) {
 		var err error
 		var tlsConnR net.Conn
@@ -22,14 +36,35 @@ func getTLSHijackFunc(hj hijackers.Hijacker) func(*http.Request, net.Conn, *gopr
 			_ = tlsConnR.Close()
 		}
 
-		tlsConnL, tlsConnR, err := hj.GetConns(req.URL, connL, ctx)
+		tlsConnL, tlsConnR, err := getConns(req.URL, connL, ctx)
 		if err != nil {
 			ctx.Warnf("Couldn't connect: %v", err)
+			var hsErr *hijackers.ClientHandshakeError
+			if learned != nil && errors.As(err, &hsErr) {
+				learnBypass(learned, connL, hsErr, ctx)
+			}
 			return
 		}
 
 		ctx.Logf("Connected to server: %s\n", tlsConnR.RemoteAddr())
 
+		if harWriter != nil {
+			tlsConnL, tlsConnR = harWriter.Conns(tlsConnL, tlsConnR, req.URL.Host)
+		}
+		if rec != nil {
+			flow, err := rec.NewFlow(connL.RemoteAddr().String(), req.URL.Host)
+			if err != nil {
+				ctx.Warnf("Error starting recording: %v", err)
+			} else {
+				tlsConnL, tlsConnR = flow.Conns(tlsConnL, tlsConnR)
+				defer func() {
+					if err := flow.Err(); err != nil {
+						ctx.Warnf("Error recording: %v", err)
+					}
+				}()
+			}
+		}
+
 		go handleServerTLSConn(tlsConnR, tlsConnL, &closer, ctx)
 
 		_, err = io.Copy(tlsConnR, tlsConnL)
@@ -41,6 +76,35 @@ func getTLSHijackFunc(hj hijackers.Hijacker) func(*http.Request, net.Conn, *gopr
 	}
 }
 
+// learnBypass records that client rejects interception of target host,
+// so that its subsequent connections to host are passed through once it is learned.
+// Host is keyed the same way as in tunnel requests; SNI is learned too, in case client tunnels by IP address.
+func learnBypass(learned *rules.Learned, client net.Conn, hsErr *hijackers.ClientHandshakeError, ctx *goproxy.ProxyCtx) {
+	clientHost := hostOnly(client.RemoteAddr().String())
+	hosts := []string{hsErr.Target}
+	if hsErr.ServerName != "" && hsErr.ServerName != hsErr.Target {
+		hosts = append(hosts, hsErr.ServerName)
+	}
+	for _, host := range hosts {
+		added, err := learned.Reject(clientHost, host, hsErr.Err.Error())
+		if err != nil {
+			ctx.Warnf("Error saving learned bypass list: %v", err)
+		}
+		if added {
+			ctx.Warnf("Client %s rejects interception of %s, it will be passed through", clientHost, host)
+		}
+	}
+}
+
+// hostOnly strips port from address
+func hostOnly(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
 func handleServerTLSConn(connR, connL net.Conn, closer *sync.Once, ctx *goproxy.ProxyCtx) {
 	closeFunc := func() {
 		ctx.Logf("Connections closed.")
@@ -0,0 +1,154 @@
+// Package transparent accepts connections redirected to proxy by firewall (iptables REDIRECT or TPROXY)
+// and recovers their original destination.
+//
+// For TLS connections target host is taken from ClientHello SNI, so that host rules and forged
+// certificates work the same way as with CONNECT requests.
+package transparent
+
+import (
+	"errors"
+	"github.com/fedosgad/mirror_proxy/fingerprint"
+	"github.com/fedosgad/mirror_proxy/utils"
+	"log"
+	"net"
+	"syscall"
+	"time"
+)
+
+// sniTimeout limits waiting for ClientHello. Protocols where server speaks first are routed
+// by destination address after it.
+const sniTimeout = 2 * time.Second
+
+// maxRecordSize is the size of the largest TLS record (with header)
+const maxRecordSize = 5 + 1<<14
+
+var errNotTCP = errors.New("not a TCP connection")
+
+// ConnectFunc returns handler of connection from client to target (original destination, "ip:port"),
+// or nil if connection is not allowed or failed. serverName is SNI sent by client, if any.
+// Handler owns the connection.
+type ConnectFunc func(client net.Addr, target, serverName string) (func(conn net.Conn), error)
+
+type Server struct {
+	connect ConnectFunc
+	tproxy  bool
+	verbose bool
+}
+
+// NewServer creates server. With tproxy original destination is the local address of connection,
+// otherwise it is obtained with SO_ORIGINAL_DST.
+func NewServer(connect ConnectFunc, tproxy bool, verbose bool) *Server {
+	return &Server{
+		connect: connect,
+		tproxy:  tproxy,
+		verbose: verbose,
+	}
+}
+
+// Serve accepts connections on l and serves them. It returns when l fails.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+func (s *Server) logf(msg string, argv ...interface{}) {
+	if s.verbose {
+		log.Printf("Transparent: "+msg, argv...)
+	}
+}
+
+func (s *Server) handle(conn net.Conn) {
+	dst, err := s.originalDst(conn)
+	if err != nil {
+		s.logf("%s: original destination: %v", conn.RemoteAddr(), err)
+		_ = conn.Close()
+		return
+	}
+	if local, ok := conn.LocalAddr().(*net.TCPAddr); ok && !s.tproxy &&
+		local.IP.Equal(dst.IP) && local.Port == dst.Port {
+		// Connecting there would connect to proxy itself
+		s.logf("%s: connection was not redirected", conn.RemoteAddr())
+		_ = conn.Close()
+		return
+	}
+
+	// Original destination is always dialed, SNI is not trusted to select host
+	pc := utils.NewPeekConnSize(conn, maxRecordSize)
+	target := dst.String()
+	sni := readSNI(pc)
+	s.logf("%s: %s (SNI %q)", conn.RemoteAddr(), target, sni)
+
+	handler, err := s.connect(conn.RemoteAddr(), target, sni)
+	if err != nil {
+		s.logf("%s: %s: %v", conn.RemoteAddr(), target, err)
+	}
+	if handler == nil {
+		_ = conn.Close()
+		return
+	}
+	handler(pc)
+}
+
+func (s *Server) originalDst(conn net.Conn) (*net.TCPAddr, error) {
+	if !s.tproxy {
+		return OriginalDst(conn)
+	}
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, errNotTCP
+	}
+	return local, nil
+}
+
+// OriginalDst returns destination of connection redirected with iptables REDIRECT.
+// Wrapping connections are unwrapped with their NetConn method (as of tls.Conn).
+func OriginalDst(conn net.Conn) (*net.TCPAddr, error) {
+	local, ok := conn.LocalAddr().(*net.TCPAddr)
+	if !ok {
+		return nil, errNotTCP
+	}
+	// IPv4 connections to dual stack socket are IPv4 ones for conntrack
+	ipv4 := local.IP.To4() != nil
+	for {
+		if sc, ok := conn.(syscall.Conn); ok {
+			return socketOriginalDst(sc, ipv4)
+		}
+		wrapper, ok := conn.(interface{ NetConn() net.Conn })
+		if !ok {
+			return nil, errors.New("no socket behind connection")
+		}
+		conn = wrapper.NetConn()
+	}
+}
+
+// readSNI returns server name from ClientHello, if client sent one
+func readSNI(pc *utils.PeekConn) string {
+	_ = pc.SetReadDeadline(time.Now().Add(sniTimeout))
+	defer func() {
+		_ = pc.SetReadDeadline(time.Time{})
+	}()
+
+	header, err := pc.PeekN(5)
+	if err != nil || header[0] != 0x16 {
+		return ""
+	}
+	record, err := pc.PeekN(5 + (int(header[3])<<8 | int(header[4])))
+	if err != nil {
+		return ""
+	}
+	ch, err := fingerprint.ParseClientHello(record)
+	if err != nil {
+		return ""
+	}
+	return ch.ServerName
+}
@@ -0,0 +1,77 @@
+package transparent
+
+import (
+	"context"
+	"encoding/binary"
+	"golang.org/x/sys/unix"
+	"net"
+	"syscall"
+)
+
+// Supported reports whether transparent listener works on this platform.
+const Supported = true
+
+// Listen listens on TCP address. With tproxy socket gets IP_TRANSPARENT option required for TPROXY
+// (and CAP_NET_ADMIN capability).
+func Listen(addr string, tproxy bool) (net.Listener, error) {
+	lc := net.ListenConfig{}
+	if tproxy {
+		lc.Control = func(network, _ string, c syscall.RawConn) error {
+			var opErr error
+			err := c.Control(func(fd uintptr) {
+				if network == "tcp6" {
+					opErr = unix.SetsockoptInt(int(fd), unix.SOL_IPV6, unix.IPV6_TRANSPARENT, 1)
+				} else {
+					opErr = unix.SetsockoptInt(int(fd), unix.SOL_IP, unix.IP_TRANSPARENT, 1)
+				}
+			})
+			if err != nil {
+				return err
+			}
+			return opErr
+		}
+	}
+	return lc.Listen(context.Background(), "tcp", addr)
+}
+
+// socketOriginalDst gets destination of connection before REDIRECT from conntrack
+func socketOriginalDst(sc syscall.Conn, ipv4 bool) (*net.TCPAddr, error) {
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var addr *net.TCPAddr
+	var opErr error
+	err = raw.Control(func(fd uintptr) {
+		if ipv4 {
+			// sockaddr_in fits into ipv6_mreq
+			mreq, err := unix.GetsockoptIPv6Mreq(int(fd), unix.SOL_IP, unix.SO_ORIGINAL_DST)
+			if err != nil {
+				opErr = err
+				return
+			}
+			sa := mreq.Multiaddr
+			addr = &net.TCPAddr{
+				IP:   net.IPv4(sa[4], sa[5], sa[6], sa[7]),
+				Port: int(sa[2])<<8 | int(sa[3]),
+			}
+			return
+		}
+		// sockaddr_in6 fits into ip6_mtuinfo; IP6T_SO_ORIGINAL_DST equals SO_ORIGINAL_DST
+		info, err := unix.GetsockoptIPv6MTUInfo(int(fd), unix.SOL_IPV6, unix.SO_ORIGINAL_DST)
+		if err != nil {
+			opErr = err
+			return
+		}
+		var port [2]byte // Port is in network byte order
+		binary.NativeEndian.PutUint16(port[:], info.Addr.Port)
+		addr = &net.TCPAddr{
+			IP:   append(net.IP(nil), info.Addr.Addr[:]...),
+			Port: int(binary.BigEndian.Uint16(port[:])),
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return addr, opErr
+}
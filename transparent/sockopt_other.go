@@ -0,0 +1,25 @@
+//go:build !linux
+
+package transparent
+
+import (
+	"errors"
+	"net"
+	"syscall"
+)
+
+// Supported reports whether transparent listener works on this platform.
+const Supported = false
+
+var errNotSupported = errors.New("transparent mode is only supported on Linux")
+
+func Listen(addr string, tproxy bool) (net.Listener, error) {
+	if tproxy {
+		return nil, errNotSupported
+	}
+	return net.Listen("tcp", addr)
+}
+
+func socketOriginalDst(syscall.Conn, bool) (*net.TCPAddr, error) {
+	return nil, errNotSupported
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/elazarl/goproxy"
+	"github.com/fedosgad/mirror_proxy/rules"
+	"net"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+)
+
+// tunnelSession numbers tunnels accepted without CONNECT request in log
+var tunnelSession atomic.Int64
+
+// getTunnelConnectFunc returns function selecting handler of tunnel accepted without CONNECT request
+// (by SOCKS5 or transparent listener). Tunnels go through the same rules and hijackers as CONNECT ones.
+// nil handler is returned for blocked targets. If dialFirst is set, passed through targets are dialed
+// with it before handler is returned, so that dial error can be reported to client.
+// Tunnel is always made to target; serverName (client's SNI, if known) is only used to select action.
+func getTunnelConnectFunc(
+	p *goproxy.ProxyHttpServer,
+	kind string,
+	actionFor func(host string, ctx *goproxy.ProxyCtx) string,
+	hijackFunc func(action string, tunnel bool) func(*http.Request, net.Conn, *goproxy.ProxyCtx),
+	dialFirst func(target string) (func(*http.Request, net.Conn, *goproxy.ProxyCtx), error),
+) func(client net.Addr, target, serverName string) (func(net.Conn), error) {
+	return func(client net.Addr, target, serverName string) (func(net.Conn), error) {
+		// Pretend it was CONNECT request, so that hijacking and logging work as usual
+		req := &http.Request{
+			Method:     http.MethodConnect,
+			URL:        &url.URL{Host: target},
+			Host:       target,
+			RemoteAddr: client.String(),
+		}
+		ctx := &goproxy.ProxyCtx{Req: req, Session: tunnelSession.Add(1), Proxy: p}
+		ctx.Logf("%s tunnel to %s from %s", kind, target, client)
+		host := target
+		if serverName != "" {
+			_, port, _ := net.SplitHostPort(target)
+			host = net.JoinHostPort(serverName, port)
+			ctx.Logf("Server name: %s", serverName)
+		}
+		action := actionFor(host, ctx)
+		if action == rules.ActionBlock {
+			return nil, nil
+		}
+		hijack := hijackFunc(action, true)
+		if action == rules.ActionPassthrough && dialFirst != nil {
+			var err error
+			hijack, err = dialFirst(target)
+			if err != nil {
+				ctx.Warnf("Couldn't connect: %v", err)
+				return nil, err
+			}
+		}
+		return func(conn net.Conn) {
+			hijack(req, conn, ctx)
+		}, nil
+	}
+}
+
+// dialedConn is dialer returning already established connection
+type dialedConn struct {
+	conn net.Conn
+}
+
+func (d dialedConn) Dial(string, string) (net.Conn, error) {
+	return d.conn, nil
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// CaptureConn records first bytes read from and written to underlying connection.
+// It is used to get hold of handshake messages sent and received by TLS libraries.
+type CaptureConn struct {
+	net.Conn
+	limit int
+	done  atomic.Bool
+
+	mu      sync.Mutex
+	read    []byte
+	written []byte
+}
+
+// NewCaptureConn creates CaptureConn which captures up to limit bytes in each direction.
+func NewCaptureConn(conn net.Conn, limit int) *CaptureConn {
+	return &CaptureConn{
+		Conn:  conn,
+		limit: limit,
+	}
+}
+
+func (c *CaptureConn) Read(p []byte) (n int, err error) {
+	n, err = c.Conn.Read(p)
+	if c.done.Load() {
+		return n, err
+	}
+	c.mu.Lock()
+	c.read = appendLimited(c.read, p[:n], c.limit)
+	c.mu.Unlock()
+	return n, err
+}
+
+func (c *CaptureConn) Write(p []byte) (n int, err error) {
+	n, err = c.Conn.Write(p)
+	if c.done.Load() {
+		return n, err
+	}
+	c.mu.Lock()
+	c.written = appendLimited(c.written, p[:n], c.limit)
+	c.mu.Unlock()
+	return n, err
+}
+
+// Stop ends capturing and returns captured incoming and outgoing data. Connection is passed through afterwards.
+func (c *CaptureConn) Stop() (read, written []byte) {
+	c.done.Store(true)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	read, written = c.read, c.written
+	c.read, c.written = nil, nil
+	return read, written
+}
+
+func appendLimited(buf, p []byte, limit int) []byte {
+	if room := limit - len(buf); room < len(p) {
+		p = p[:max(room, 0)]
+	}
+	return append(buf, p...)
+}
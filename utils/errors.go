@@ -1,8 +1,10 @@
 package utils
 
-import "net"
+import (
+	"errors"
+	"net"
+)
 
 func IsClosedConnErr(err error) bool {
-	netOpError, ok := err.(*net.OpError)
-	return ok && netOpError.Err.Error() == "use of closed network connection"
+	return errors.Is(err, net.ErrClosed)
 }
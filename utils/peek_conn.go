@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"bufio"
+	"net"
+)
+
+// PeekConn allows to look at incoming data without consuming it.
+type PeekConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func NewPeekConn(conn net.Conn) *PeekConn {
+	return &PeekConn{
+		Conn: conn,
+		r:    bufio.NewReader(conn),
+	}
+}
+
+// NewPeekConnSize creates PeekConn able to look at up to size bytes.
+func NewPeekConnSize(conn net.Conn, size int) *PeekConn {
+	return &PeekConn{
+		Conn: conn,
+		r:    bufio.NewReaderSize(conn, size),
+	}
+}
+
+// Peek returns at least one byte of incoming data (all that is available without blocking further).
+// Data will still be returned by Read.
+func (pc *PeekConn) Peek() ([]byte, error) {
+	if _, err := pc.r.Peek(1); err != nil {
+		return nil, err
+	}
+	return pc.r.Peek(pc.r.Buffered())
+}
+
+// PeekN returns exactly n bytes of incoming data, waiting for them if necessary.
+// Data will still be returned by Read.
+func (pc *PeekConn) PeekN(n int) ([]byte, error) {
+	return pc.r.Peek(n)
+}
+
+func (pc *PeekConn) Read(p []byte) (n int, err error) {
+	return pc.r.Read(p)
+}
@@ -15,7 +15,7 @@ type TeeConn struct {
 
 func NewTeeConn(conn net.Conn) (net.Conn, io.Reader) {
 	pipeR, pipeW := io.Pipe()
-	teeOut := io.TeeReader(conn, pipeW)
+	teeOut := &teeReader{r: conn, w: pipeW}
 	return &TeeConn{
 		Conn:   conn,
 		pipeR:  pipeR,
@@ -37,3 +37,23 @@ func (tc TeeConn) Close() error {
 	}
 	return nil
 }
+
+// teeReader is like io.TeeReader, but also passes read error (e.g. EOF) to pipe,
+// so that reader of TeeConn sees connection end
+type teeReader struct {
+	r io.Reader
+	w *io.PipeWriter
+}
+
+func (t *teeReader) Read(p []byte) (n int, err error) {
+	n, err = t.r.Read(p)
+	if n > 0 {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err != nil {
+		_ = t.w.CloseWithError(err)
+	}
+	return n, err
+}